@@ -0,0 +1,337 @@
+package hooks
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/security"
+
+	_ "cc-hub/migrations"
+)
+
+// appMigrations are the migrations of this repo. They are taken out of
+// core.AppMigrations, so the test apps created from the PocketBase test data
+// (newLicenseTestApp, newOutboxTestApp) don't run them.
+var appMigrations = takeAppMigrations()
+
+func takeAppMigrations() core.MigrationsList {
+	migrations := core.AppMigrations
+	core.AppMigrations = core.MigrationsList{}
+	return migrations
+}
+
+const testLicenseKeySecret = "test-license-key-secret-0123456789"
+
+var (
+	// testSigningKey is the LICENSE_SIGNING_KEY of the test apps.
+	testSigningKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{1}, ed25519.SeedSize))
+
+	// testUpdateKey signs the artifacts of the test apps, see UPDATE_SIGNING_PUBLIC_KEYS.
+	testUpdateKey = ed25519.NewKeyFromSeed(bytes.Repeat([]byte{2}, ed25519.SeedSize))
+)
+
+// newHubTestApp creates a test app from pb_data, migrated to the current schema
+// and with every hook and route of Register.
+func newHubTestApp(t *testing.T) *tests.TestApp {
+	t.Helper()
+
+	t.Setenv("LICENSE_KEY_SECRET", testLicenseKeySecret)
+	t.Setenv("LICENSE_SIGNING_KEY", "test:"+base64.StdEncoding.EncodeToString(testSigningKey.Seed()))
+	t.Setenv("UPDATE_SIGNING_PUBLIC_KEYS", "test:"+base64.StdEncoding.EncodeToString(testUpdateKey.Public().(ed25519.PublicKey)))
+
+	app := newMigratedTestApp(t, appMigrations)
+	if err := Register(app); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// newMigratedTestApp creates a test app from pb_data with the given migrations applied.
+func newMigratedTestApp(t *testing.T, migrations core.MigrationsList) *tests.TestApp {
+	t.Helper()
+
+	app, err := tests.NewTestApp("../pb_data")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	if _, err := core.NewMigrationsRunner(app, migrations).Up(); err != nil {
+		t.Fatal(err)
+	}
+	return app
+}
+
+// newTestRouter serves the routes of app the way "serve" does.
+func newTestRouter(t *testing.T, app core.App) http.Handler {
+	t.Helper()
+
+	router, err := apis.NewRouter(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mux http.Handler
+	event := &core.ServeEvent{App: app, Router: router}
+	err = app.OnServe().Trigger(event, func(e *core.ServeEvent) error {
+		var err error
+		mux, err = e.Router.BuildMux()
+		return err
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return mux
+}
+
+// sendTestRequest sends a request with a JSON body through handler.
+func sendTestRequest(handler http.Handler, method, url string, body any, header http.Header) *httptest.ResponseRecorder {
+	var reader *strings.Reader
+	switch b := body.(type) {
+	case nil:
+		reader = strings.NewReader("")
+	case string:
+		reader = strings.NewReader(b)
+	default:
+		encoded, _ := json.Marshal(b)
+		reader = strings.NewReader(string(encoded))
+	}
+
+	req := httptest.NewRequest(method, url, reader)
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range header {
+		req.Header[name] = values
+	}
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, req)
+	return rec
+}
+
+// decodeTestResponse decodes the JSON body of rec into v, failing unless the status is expectedStatus.
+func decodeTestResponse(t *testing.T, rec *httptest.ResponseRecorder, expectedStatus int, v any) {
+	t.Helper()
+
+	if rec.Code != expectedStatus {
+		t.Fatalf("expected status %d, got %d: %s", expectedStatus, rec.Code, rec.Body.String())
+	}
+	if v != nil {
+		if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+			t.Fatalf("invalid response %q: %v", rec.Body.String(), err)
+		}
+	}
+}
+
+// saveTestRecord creates a record of collection with fields, failing the test on errors.
+func saveTestRecord(t *testing.T, app core.App, collection string, fields map[string]any) *core.Record {
+	t.Helper()
+
+	c, err := app.FindCollectionByNameOrId(collection)
+	if err != nil {
+		t.Fatal(err)
+	}
+	record := core.NewRecord(c)
+	record.Load(fields)
+	if err := app.Save(record); err != nil {
+		t.Fatalf("saving %s: %v", collection, err)
+	}
+	return record
+}
+
+// newTestUser returns the customer with email, creating it if needed.
+func newTestUser(t *testing.T, app core.App, email string) *core.Record {
+	t.Helper()
+
+	user, err := app.FindAuthRecordByEmail("users", email)
+	if err == nil {
+		return user
+	}
+	password := security.RandomString(20)
+	return saveTestRecord(t, app, "users", map[string]any{
+		"email":           email,
+		"name":            "Test Customer",
+		"password":        password,
+		"passwordConfirm": password,
+	})
+}
+
+// newTestLicense issues an active license to the customer with email and returns it with its key.
+func newTestLicense(t *testing.T, app core.App, email string, fields map[string]any) (*core.Record, string) {
+	t.Helper()
+
+	key, err := GenerateUniqueKey(app)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	collection, err := app.FindCollectionByNameOrId("licenses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	license := core.NewRecord(collection)
+	license.Load(map[string]any{
+		"user":             newTestUser(t, app, email).Id,
+		"purchase_id":      "pi_" + security.RandomString(10),
+		"status":           "active",
+		"tier":             "pro",
+		"activation_limit": defaultActivationLimit,
+	})
+	license.Load(fields)
+	if err := setLicenseKey(license, key); err != nil {
+		t.Fatal(err)
+	}
+	if err := app.Save(license); err != nil {
+		t.Fatal(err)
+	}
+	return license, key
+}
+
+// newTestDevice activates deviceID on license.
+func newTestDevice(t *testing.T, app core.App, license *core.Record, deviceID string) *core.Record {
+	t.Helper()
+
+	return saveTestRecord(t, app, "devices", map[string]any{
+		"license":   license.Id,
+		"device_id": deviceID,
+		"name":      "Test Mac",
+	})
+}
+
+// newTestVersion publishes a stable build, fully rolled out unless fields say otherwise.
+func newTestVersion(t *testing.T, app core.App, build int, fields map[string]any) *core.Record {
+	t.Helper()
+
+	values := map[string]any{
+		"build_number":       build,
+		"version_string":     fmt.Sprintf("1.%d", build),
+		"release_notes":      fmt.Sprintf("Build %d", build),
+		"is_published":       true,
+		"channel":            channelStable,
+		"rollout_percentage": 100,
+	}
+	for name, value := range fields {
+		values[name] = value
+	}
+	return saveTestRecord(t, app, "versions", values)
+}
+
+// newTestArtifact uploads a build of version for os and arch, signed with testUpdateKey.
+func newTestArtifact(t *testing.T, app core.App, version *core.Record, os, arch string, fields map[string]any) *core.Record {
+	t.Helper()
+
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	w, err := zw.Create("App")
+	if err == nil {
+		_, err = fmt.Fprintf(w, "build %d for %s %s", version.GetInt("build_number"), os, arch)
+	}
+	if err == nil {
+		err = zw.Close()
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := archive.Bytes()
+
+	file, err := filesystem.NewFileFromBytes(data, "App.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := map[string]any{
+		"version":         version.Id,
+		"os":              os,
+		"arch":            arch,
+		"file":            file,
+		"signature_eddsa": base64.StdEncoding.EncodeToString(ed25519.Sign(testUpdateKey, data)),
+	}
+	for name, value := range fields {
+		values[name] = value
+	}
+	return saveTestRecord(t, app, "artifacts", values)
+}
+
+// newTestSuperuserToken returns the auth token of a new superuser.
+func newTestSuperuserToken(t *testing.T, app core.App) string {
+	t.Helper()
+
+	superuser := saveTestRecord(t, app, core.CollectionNameSuperusers, map[string]any{
+		"email":    "admin@example.com",
+		"password": "1234567890",
+	})
+	token, err := superuser.NewAuthToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+// countTestRecords counts the records of collection matching exp.
+func countTestRecords(t *testing.T, app core.App, collection string, exp dbx.Expression) int {
+	t.Helper()
+
+	n, err := app.CountRecords(collection, exp)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(n)
+}
+
+// testAppCheck is the response of /api/v1/app_check.
+type testAppCheck struct {
+	Activation map[string]string `json:"activation"`
+	Update     *struct {
+		ForceUpdate   bool   `json:"force_update"`
+		VersionString string `json:"version_string"`
+		Channel       string `json:"channel"`
+		OS            string `json:"os"`
+		Arch          string `json:"arch"`
+		MinOSVersion  string `json:"min_os_version"`
+		DownloadURL   string `json:"download_url"`
+		Rollback      *struct {
+			YankedBuild int    `json:"yanked_build"`
+			Reason      string `json:"reason"`
+		} `json:"rollback"`
+	} `json:"update"`
+	Upgrade *struct {
+		VersionString string `json:"version_string"`
+	} `json:"upgrade"`
+}
+
+// updateVersion returns the version_string of the offered update, or "" without one.
+func (c testAppCheck) updateVersion() string {
+	if c.Update == nil {
+		return ""
+	}
+	return c.Update.VersionString
+}
+
+// upgradeVersion returns the version_string of the upgrade prompt, or "" without one.
+func (c testAppCheck) upgradeVersion() string {
+	if c.Upgrade == nil {
+		return ""
+	}
+	return c.Upgrade.VersionString
+}
+
+// sendTestAppCheck checks for updates with body.
+func sendTestAppCheck(t *testing.T, handler http.Handler, body map[string]any) testAppCheck {
+	t.Helper()
+
+	var check testAppCheck
+	decodeTestResponse(t, sendTestRequest(handler, http.MethodPost, "/api/v1/app_check", body, nil), http.StatusOK, &check)
+	return check
+}
@@ -0,0 +1,223 @@
+package hooks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	appcastTitle     = "CursorClip Recorder"
+	sparkleNamespace = "http://www.andymatuschak.org/xml-namespaces/sparkle"
+)
+
+// Sparkle appcast, see https://sparkle-project.org/documentation/publishing/
+type appcastFeed struct {
+	XMLName      xml.Name       `xml:"rss"`
+	Version      string         `xml:"version,attr"`
+	SparkleXMLNS string         `xml:"xmlns:sparkle,attr"`
+	Channel      appcastChannel `xml:"channel"`
+}
+
+type appcastChannel struct {
+	Title string        `xml:"title"`
+	Link  string        `xml:"link"`
+	Items []appcastItem `xml:"item"`
+}
+
+type appcastItem struct {
+	Title                string           `xml:"title"`
+	PubDate              string           `xml:"pubDate,omitempty"`
+	Version              string           `xml:"sparkle:version"`
+	ShortVersionString   string           `xml:"sparkle:shortVersionString"`
+	MinimumSystemVersion string           `xml:"sparkle:minimumSystemVersion,omitempty"`
+	CriticalUpdate       *appcastCritical `xml:"sparkle:criticalUpdate"`
+	Description          appcastCDATA     `xml:"description"`
+	Enclosure            appcastEnclosure `xml:"enclosure"`
+}
+
+// appcastCritical marks the update as critical for every build below Version.
+type appcastCritical struct {
+	Version string `xml:"sparkle:version,attr"`
+}
+
+type appcastCDATA struct {
+	Text string `xml:",cdata"`
+}
+
+type appcastEnclosure struct {
+	URL         string `xml:"url,attr"`
+	Length      int    `xml:"length,attr"`
+	Type        string `xml:"type,attr"`
+	EdSignature string `xml:"sparkle:edSignature,attr"`
+}
+
+// appcastCache keeps the rendered feeds until a version or artifact record changes.
+// Feeds are cached per channel, architecture and base URL, and only when the base URL
+// is configured: the request host is picked by the client, so caching per host
+// would let anyone grow the cache without bound.
+type appcastCache struct {
+	mu    sync.Mutex
+	feeds map[string][]byte
+}
+
+// newAppcastCache creates the cache and binds its invalidation to the versions collection.
+func newAppcastCache(app core.App) *appcastCache {
+	c := &appcastCache{feeds: map[string][]byte{}}
+
+	invalidate := func(e *core.RecordEvent) error {
+		c.invalidate()
+		return e.Next()
+	}
+	app.OnRecordAfterCreateSuccess("versions").BindFunc(invalidate)
+	app.OnRecordAfterUpdateSuccess("versions").BindFunc(invalidate)
+	app.OnRecordAfterDeleteSuccess("versions").BindFunc(invalidate)
+	app.OnRecordAfterCreateSuccess("artifacts").BindFunc(invalidate)
+	app.OnRecordAfterUpdateSuccess("artifacts").BindFunc(invalidate)
+	app.OnRecordAfterDeleteSuccess("artifacts").BindFunc(invalidate)
+
+	return c
+}
+
+func (c *appcastCache) get(key string) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	feed, ok := c.feeds[key]
+	return feed, ok
+}
+
+func (c *appcastCache) set(key string, feed []byte) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.feeds[key] = feed
+}
+
+func (c *appcastCache) invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.feeds = map[string][]byte{}
+}
+
+// handleAppcast serves the published versions as a Sparkle appcast.
+// Testers point their feed URL at e.g. /api/v1/appcast.xml?channel=beta, and apps
+// shipping thin builds add their architecture, e.g. ?arch=arm64.
+func handleAppcast(app core.App, cache *appcastCache) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		channel, ok := parseReleaseChannel(e.Request.URL.Query().Get("channel"))
+		if !ok {
+			return apis.NewBadRequestError("Unknown release channel", nil)
+		}
+		channel = resolveReleaseChannel(channel, nil, nil)
+		arch, ok := normalizeArch(e.Request.URL.Query().Get("arch"))
+		if !ok {
+			return apis.NewBadRequestError("Unknown architecture", nil)
+		}
+
+		baseURL := publicBaseURL(e)
+		cacheable := configuredBaseURL(e.App) != ""
+		cacheKey := channel + " " + arch + " " + baseURL
+
+		feed, ok := cache.get(cacheKey)
+		if !ok {
+			var err error
+			feed, err = renderAppcast(e, baseURL, channel, arch)
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Could not render the appcast.", err)
+			}
+			if cacheable {
+				cache.set(cacheKey, feed)
+			}
+		}
+
+		sum := sha256.Sum256(feed)
+		etag := `"` + hex.EncodeToString(sum[:8]) + `"`
+		e.Response.Header().Set("ETag", etag)
+		e.Response.Header().Set("Cache-Control", "public, max-age=300")
+		if e.Request.Header.Get("If-None-Match") == etag {
+			return e.NoContent(http.StatusNotModified)
+		}
+
+		return e.Blob(http.StatusOK, "application/rss+xml; charset=utf-8", feed)
+	}
+}
+
+// renderAppcast builds the feed from the macOS builds of the versions published on channel, newest first.
+// Sparkle clients can't be bucketed by device, so staged rollouts only show up once complete.
+// Items have no sparkle:deltas, the deltas we generate are in our own format, see delta.go.
+func renderAppcast(e *core.RequestEvent, baseURL string, channel string, arch string) ([]byte, error) {
+	channelExpr, params := channelFilter(channel)
+	versions, err := e.App.FindRecordsByFilter(
+		"versions",
+		"is_published = true && is_yanked = false && rollout_percentage >= 100 && rollout_state = '' && "+channelExpr,
+		"-build_number",
+		0, 0,
+		params,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	feed := appcastFeed{
+		Version:      "2.0",
+		SparkleXMLNS: sparkleNamespace,
+		Channel: appcastChannel{
+			Title: appcastTitle,
+			Link:  baseURL + "/api/v1/appcast.xml?channel=" + channel,
+		},
+	}
+
+	for _, version := range versions {
+		artifact, err := findVersionArtifact(e.App, version, platformMacOS, arch, "")
+		if err != nil {
+			return nil, err
+		}
+		if artifact == nil {
+			continue
+		}
+
+		downloadURL, err := recordFileURL(e, artifact, artifact.GetString("file"))
+		if err != nil {
+			return nil, err
+		}
+
+		item := appcastItem{
+			Title:                "Version " + version.GetString("version_string"),
+			Version:              strconv.Itoa(version.GetInt("build_number")),
+			ShortVersionString:   version.GetString("version_string"),
+			MinimumSystemVersion: artifact.GetString("min_os_version"),
+			Description:          appcastCDATA{Text: version.GetString("release_notes")},
+			Enclosure: appcastEnclosure{
+				URL:         downloadURL,
+				Length:      artifact.GetInt("size"),
+				Type:        "application/octet-stream",
+				EdSignature: artifact.GetString("signature_eddsa"),
+			},
+		}
+
+		item.PubDate = versionReleasedAt(version).Time().Format(time.RFC1123Z)
+
+		// Builds below min_required_build must update, same as force_update in app_check.
+		if minRequiredBuild := version.GetInt("min_required_build"); minRequiredBuild > 0 {
+			item.CriticalUpdate = &appcastCritical{Version: strconv.Itoa(minRequiredBuild)}
+		}
+
+		feed.Channel.Items = append(feed.Channel.Items, item)
+	}
+
+	out, err := xml.MarshalIndent(feed, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), out...), nil
+}
@@ -0,0 +1,139 @@
+package hooks
+
+import (
+	"encoding/xml"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// testAppcast is the part of a Sparkle appcast the tests check.
+type testAppcast struct {
+	Items []struct {
+		Version              string `xml:"version"`
+		MinimumSystemVersion string `xml:"minimumSystemVersion"`
+		CriticalUpdate       *struct {
+			Version string `xml:"version,attr"`
+		} `xml:"criticalUpdate"`
+		Enclosure struct {
+			URL         string `xml:"url,attr"`
+			Length      int    `xml:"length,attr"`
+			EdSignature string `xml:"edSignature,attr"`
+		} `xml:"enclosure"`
+	} `xml:"channel>item"`
+}
+
+// builds returns the sparkle:version of each item.
+func (a testAppcast) builds() string {
+	var builds []string
+	for _, item := range a.Items {
+		builds = append(builds, item.Version)
+	}
+	return strings.Join(builds, ",")
+}
+
+func fetchTestAppcast(t *testing.T, handler http.Handler, query string) testAppcast {
+	t.Helper()
+
+	rec := sendTestRequest(handler, http.MethodGet, "/api/v1/appcast.xml"+query, nil, nil)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+	}
+	var feed testAppcast
+	if err := xml.Unmarshal(rec.Body.Bytes(), &feed); err != nil {
+		t.Fatalf("invalid appcast %q: %v", rec.Body.String(), err)
+	}
+	return feed
+}
+
+func TestAppcast(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	stable := newTestVersion(t, app, 9, map[string]any{"min_required_build": 8})
+	universal := newTestArtifact(t, app, stable, platformMacOS, archUniversal, nil)
+	arm64 := newTestArtifact(t, app, stable, platformMacOS, archARM64, map[string]any{"min_os_version": "14.0"})
+
+	versions := []struct {
+		build  int
+		fields map[string]any
+		os     string
+	}{
+		{10, map[string]any{"channel": channelBeta}, platformMacOS},
+		{11, map[string]any{"channel": channelNightly}, platformMacOS},
+		{12, map[string]any{"is_yanked": true}, platformMacOS},
+		{13, map[string]any{"rollout_percentage": 50}, platformMacOS},
+		{14, map[string]any{"rollout_state": rolloutPaused}, platformMacOS},
+		{15, nil, platformWindows},
+		{16, map[string]any{"is_published": false}, platformMacOS},
+	}
+	for _, v := range versions {
+		newTestArtifact(t, app, newTestVersion(t, app, v.build, v.fields), v.os, archUniversal, nil)
+	}
+
+	scenarios := []struct {
+		query    string
+		expected string
+	}{
+		{"", "9"},
+		{"?channel=stable", "9"},
+		{"?channel=beta", "10,9"},
+		{"?channel=nightly", "11,10,9"},
+		{"?arch=arm64", "9"},
+		{"?arch=x86_64&channel=beta", "10,9"},
+	}
+	for _, s := range scenarios {
+		if builds := fetchTestAppcast(t, router, s.query).builds(); builds != s.expected {
+			t.Errorf("%q: expected the builds %q, got %q", s.query, s.expected, builds)
+		}
+	}
+
+	// The exact architecture is preferred over the universal build.
+	for query, artifact := range map[string]string{"": universal.Id, "?arch=arm64": arm64.Id, "?arch=x86_64": universal.Id} {
+		item := fetchTestAppcast(t, router, query).Items[0]
+		if !strings.Contains(item.Enclosure.URL, "/"+artifact+"/") {
+			t.Errorf("%q: expected the artifact %s, got %s", query, artifact, item.Enclosure.URL)
+		}
+	}
+
+	item := fetchTestAppcast(t, router, "?arch=arm64").Items[0]
+	if item.MinimumSystemVersion != "14.0" {
+		t.Fatalf("expected the minimum system version 14.0, got %q", item.MinimumSystemVersion)
+	}
+	if item.Enclosure.Length != arm64.GetInt("size") || item.Enclosure.EdSignature != arm64.GetString("signature_eddsa") {
+		t.Fatalf("unexpected enclosure %+v", item.Enclosure)
+	}
+	if item.CriticalUpdate == nil || item.CriticalUpdate.Version != "8" {
+		t.Fatalf("expected builds below 8 to be marked critical, got %+v", item.CriticalUpdate)
+	}
+
+	for _, query := range []string{"?channel=alpha", "?arch=ppc"} {
+		if rec := sendTestRequest(router, http.MethodGet, "/api/v1/appcast.xml"+query, nil, nil); rec.Code != http.StatusBadRequest {
+			t.Errorf("%q: expected 400, got %d", query, rec.Code)
+		}
+	}
+}
+
+func TestAppcastETag(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+	newTestArtifact(t, app, newTestVersion(t, app, 1, nil), platformMacOS, archUniversal, nil)
+
+	rec := sendTestRequest(router, http.MethodGet, "/api/v1/appcast.xml", nil, nil)
+	etag := rec.Header().Get("ETag")
+	if rec.Code != http.StatusOK || etag == "" {
+		t.Fatalf("expected an ETag, got %d %q", rec.Code, etag)
+	}
+
+	rec = sendTestRequest(router, http.MethodGet, "/api/v1/appcast.xml", nil, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusNotModified {
+		t.Fatalf("expected 304, got %d", rec.Code)
+	}
+
+	// A new release changes the feed.
+	newTestArtifact(t, app, newTestVersion(t, app, 2, nil), platformMacOS, archUniversal, nil)
+	rec = sendTestRequest(router, http.MethodGet, "/api/v1/appcast.xml", nil, http.Header{"If-None-Match": {etag}})
+	if rec.Code != http.StatusOK {
+		t.Fatalf("expected the new feed, got %d", rec.Code)
+	}
+}
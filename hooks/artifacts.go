@@ -0,0 +1,255 @@
+package hooks
+
+import (
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+var (
+	errNoUpdateKeys        = errors.New("no update signing public keys configured (UPDATE_SIGNING_PUBLIC_KEYS)")
+	errUpdateSignature     = errors.New("the signature doesn't match the binary for any of the configured update signing keys")
+	errUpdateSignatureForm = errors.New("the signature must be a base64 encoded Ed25519 signature")
+)
+
+// updatePublicKey is one of the Ed25519 keys release binaries are signed with (Sparkle's SUPublicEDKey).
+type updatePublicKey struct {
+	ID  string
+	Key ed25519.PublicKey
+}
+
+// updatePublicKeys reads UPDATE_SIGNING_PUBLIC_KEYS, a comma separated list of
+// "<key id>:<base64 public key>". Several keys can be listed while rotating.
+func updatePublicKeys() ([]updatePublicKey, error) {
+	var keys []updatePublicKey
+	for _, raw := range envSecrets("UPDATE_SIGNING_PUBLIC_KEYS") {
+		keyID, encoded, ok := strings.Cut(raw, ":")
+		if !ok || keyID == "" {
+			return nil, errors.New("UPDATE_SIGNING_PUBLIC_KEYS entries must be formatted as <key id>:<base64 key>")
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(decoded) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid public key %q in UPDATE_SIGNING_PUBLIC_KEYS", keyID)
+		}
+
+		keys = append(keys, updatePublicKey{ID: keyID, Key: ed25519.PublicKey(decoded)})
+	}
+	if len(keys) == 0 {
+		return nil, errNoUpdateKeys
+	}
+	return keys, nil
+}
+
+// verifyUpdateSignature checks a base64 Ed25519 signature of data and returns the id of the matching key.
+func verifyUpdateSignature(data []byte, signature string) (string, error) {
+	keys, err := updatePublicKeys()
+	if err != nil {
+		return "", err
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(sig) != ed25519.SignatureSize {
+		return "", errUpdateSignatureForm
+	}
+
+	for _, key := range keys {
+		if ed25519.Verify(key.Key, data, sig) {
+			return key.ID, nil
+		}
+	}
+	return "", errUpdateSignature
+}
+
+// registerArtifactHooks keeps the derived fields of the artifacts collection in sync with the uploaded file.
+func registerArtifactHooks(app core.App) {
+	app.OnRecordCreate("artifacts").BindFunc(checkArtifactFile)
+	app.OnRecordUpdate("artifacts").BindFunc(checkArtifactFile)
+}
+
+// checkArtifactFile runs whenever the file or its signature changes. It stores the
+// byte size and SHA-256 digest of the file, so update responses don't need to read
+// it again, and refuses the save unless signature_eddsa verifies against one of
+// the update signing keys. A wrong signature would otherwise break auto-update for everyone.
+//
+// When the file of an existing artifact is replaced, its deltas are deleted, they
+// were generated from the previous file.
+func checkArtifactFile(e *core.RecordEvent) error {
+	files := e.Record.GetUnsavedFiles("file")
+	signatureChanged := e.Record.IsNew() ||
+		e.Record.Original().GetString("signature_eddsa") != e.Record.GetString("signature_eddsa")
+	if len(files) == 0 && !signatureChanged {
+		return e.Next()
+	}
+
+	data, err := readArtifactFile(e.App, e.Record)
+	if err != nil {
+		return err
+	}
+	if data == nil {
+		return e.Next() // no file, left to the required field validation
+	}
+
+	sum := sha256.Sum256(data)
+	e.Record.Set("size", len(data))
+	e.Record.Set("sha256", hex.EncodeToString(sum[:]))
+
+	keyID, err := verifyUpdateSignature(data, e.Record.GetString("signature_eddsa"))
+	if err != nil {
+		e.App.Logger().Warn(
+			"Refused artifact with an invalid signature",
+			"version", e.Record.GetString("version"),
+			"os", e.Record.GetString("os"),
+			"arch", e.Record.GetString("arch"),
+			"error", err.Error(),
+		)
+		return validation.Errors{
+			"signature_eddsa": validation.NewError("validation_invalid_signature", err.Error()),
+		}
+	}
+	e.Record.Set("signature_key_id", keyID)
+
+	replaced := len(files) > 0 && !e.Record.IsNew()
+	if err := e.Next(); err != nil {
+		return err
+	}
+	if replaced {
+		return deleteArtifactDeltas(e.App, e.Record)
+	}
+	return nil
+}
+
+// readArtifactFile returns the content of the artifact file, either the one being
+// uploaded or the one already stored. It returns nil if the artifact has no file.
+// Ed25519 signs the whole message, so the file is read in full.
+func readArtifactFile(app core.App, artifact *core.Record) ([]byte, error) {
+	if files := artifact.GetUnsavedFiles("file"); len(files) > 0 {
+		r, err := files[0].Reader.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+		return io.ReadAll(r)
+	}
+
+	filename := artifact.GetString("file")
+	if filename == "" {
+		return nil, nil
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	r, err := fsys.GetReader(artifact.BaseFilesPath() + "/" + filename)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+const (
+	platformMacOS   = "macos"
+	platformWindows = "windows"
+	platformLinux   = "linux"
+
+	archUniversal = "universal"
+	archARM64     = "arm64"
+	archX86_64    = "x86_64"
+)
+
+// normalizePlatform maps the names clients report (GOOS, Swift, .NET) to the artifacts os values.
+// Clients that don't send a platform predate Windows builds and are macOS apps.
+func normalizePlatform(raw string) string {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "macos", "darwin", "mac", "osx", "macosx":
+		return platformMacOS
+	case "windows", "win", "win32", "win64":
+		return platformWindows
+	case "linux":
+		return platformLinux
+	}
+	return strings.ToLower(strings.TrimSpace(raw))
+}
+
+// normalizeArch maps the architecture names clients report to the artifacts arch values.
+// An empty arch only matches universal builds, unknown ones are invalid.
+func normalizeArch(raw string) (string, bool) {
+	switch strings.ToLower(strings.TrimSpace(raw)) {
+	case "", "universal":
+		return archUniversal, true
+	case "arm64", "aarch64", "arm64e":
+		return archARM64, true
+	case "x86_64", "amd64", "x64", "x86-64":
+		return archX86_64, true
+	}
+	return "", false
+}
+
+// findVersionArtifact returns the artifact of version that fits a client, or nil if there is none.
+// A build for the client's exact architecture is preferred over a universal one, and
+// artifacts requiring a newer OS than osVersion are skipped (when the client sent it).
+func findVersionArtifact(app core.App, version *core.Record, platform, arch, osVersion string) (*core.Record, error) {
+	artifacts, err := app.FindAllRecords("artifacts", dbx.HashExp{"version": version.Id, "os": platform})
+	if err != nil {
+		return nil, err
+	}
+
+	var universal *core.Record
+	for _, artifact := range artifacts {
+		if osVersion != "" && compareVersions(osVersion, artifact.GetString("min_os_version")) < 0 {
+			continue
+		}
+		switch artifact.GetString("arch") {
+		case arch:
+			return artifact, nil
+		case archUniversal:
+			universal = artifact
+		}
+	}
+	return universal, nil
+}
+
+var osVersionPattern = regexp.MustCompile(`\d+(\.\d+)*`)
+
+// parseOSVersion extracts the dotted version from what the client reports,
+// e.g. "Version 14.2.1 (Build 23C71)" gives "14.2.1". It returns "" if there is none.
+func parseOSVersion(raw string) string {
+	return osVersionPattern.FindString(raw)
+}
+
+// compareVersions compares dotted version strings numerically, e.g. "13.10" > "13.9".
+// Missing components count as 0, so "14" == "14.0".
+func compareVersions(a, b string) int {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(strings.TrimSpace(as[i]))
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(strings.TrimSpace(bs[i]))
+		}
+		if x != y {
+			if x < y {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
@@ -0,0 +1,154 @@
+package hooks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+func TestNormalizePlatformAndArch(t *testing.T) {
+	platforms := map[string]string{
+		"":        platformMacOS,
+		"Darwin":  platformMacOS,
+		"macOS":   platformMacOS,
+		"win64":   platformWindows,
+		"Windows": platformWindows,
+		"linux":   platformLinux,
+		"FreeBSD": "freebsd",
+	}
+	for raw, expected := range platforms {
+		if got := normalizePlatform(raw); got != expected {
+			t.Errorf("platform %q: expected %q, got %q", raw, expected, got)
+		}
+	}
+
+	archs := map[string]string{
+		"":          archUniversal,
+		"universal": archUniversal,
+		"aarch64":   archARM64,
+		"ARM64":     archARM64,
+		"amd64":     archX86_64,
+		"x64":       archX86_64,
+		"ppc":       "",
+	}
+	for raw, expected := range archs {
+		got, ok := normalizeArch(raw)
+		if got != expected || ok != (expected != "") {
+			t.Errorf("arch %q: expected %q, got %q %v", raw, expected, got, ok)
+		}
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	scenarios := []struct {
+		a, b     string
+		expected int
+	}{
+		{"14", "14.0", 0},
+		{"13.10", "13.9", 1},
+		{"13.9", "13.10", -1},
+		{"14.2.1", "14.2", 1},
+		{"10.15.7", "11.0", -1},
+		{"14.0", "", 1},
+	}
+	for _, s := range scenarios {
+		if got := compareVersions(s.a, s.b); got != s.expected {
+			t.Errorf("%q vs %q: expected %d, got %d", s.a, s.b, s.expected, got)
+		}
+	}
+
+	if got := parseOSVersion("Version 14.2.1 (Build 23C71)"); got != "14.2.1" {
+		t.Fatalf("expected 14.2.1, got %q", got)
+	}
+}
+
+func TestArtifactFileChecks(t *testing.T) {
+	app := newHubTestApp(t)
+	version := newTestVersion(t, app, 1, nil)
+
+	artifact := newTestArtifact(t, app, version, platformMacOS, archUniversal, nil)
+	data, err := readArtifactFile(app, artifact)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sum := sha256.Sum256(data)
+	if artifact.GetInt("size") != len(data) || artifact.GetString("sha256") != hex.EncodeToString(sum[:]) {
+		t.Fatalf("expected the size and digest of the file, got %d %s", artifact.GetInt("size"), artifact.GetString("sha256"))
+	}
+	if artifact.GetString("signature_key_id") != "test" {
+		t.Fatalf("expected the signing key id, got %q", artifact.GetString("signature_key_id"))
+	}
+
+	// A signature made for another file is refused.
+	file, err := filesystem.NewFileFromBytes(data, "App.zip")
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := newTestArtifact(t, app, version, platformWindows, archX86_64, nil)
+	forged.Set("file", file)
+	if err := app.Save(forged); err == nil {
+		t.Fatal("expected a file that doesn't match the signature to be refused")
+	}
+}
+
+func TestAppCheckArtifacts(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	version := newTestVersion(t, app, 2, nil)
+	universal := newTestArtifact(t, app, version, platformMacOS, archUniversal, map[string]any{"min_os_version": "12.0"})
+	arm64 := newTestArtifact(t, app, version, platformMacOS, archARM64, map[string]any{"min_os_version": "14.0"})
+	newTestArtifact(t, app, version, platformWindows, archX86_64, nil)
+	windowsOnly := newTestVersion(t, app, 3, nil)
+	windowsLatest := newTestArtifact(t, app, windowsOnly, platformWindows, archX86_64, nil)
+
+	license, key := newTestLicense(t, app, "artifacts@example.com", nil)
+	newTestDevice(t, app, license, "mac")
+
+	scenarios := []struct {
+		name     string
+		body     map[string]any
+		expected string // artifact id, "" for no update
+	}{
+		{"no platform nor arch", map[string]any{}, universal.Id},
+		{"arm64", map[string]any{"platform": "macos", "arch": "arm64"}, arm64.Id},
+		{"arm64 on an OS too old for the thin build", map[string]any{"arch": "aarch64", "osVersion": "Version 13.6 (Build 22G120)"}, universal.Id},
+		{"arm64 on a recent OS", map[string]any{"arch": "arm64", "osVersion": "14.2"}, arm64.Id},
+		{"x86_64 falls back to universal", map[string]any{"arch": "x86_64"}, universal.Id},
+		{"OS too old for every build", map[string]any{"arch": "arm64", "osVersion": "11.7"}, ""},
+		{"windows gets the newest windows build", map[string]any{"platform": "win64", "arch": "amd64"}, windowsLatest.Id},
+		{"windows from the previous build", map[string]any{"platform": "windows", "arch": "x64", "current_build_number": 2}, windowsLatest.Id},
+		{"macOS has no newer build", map[string]any{"current_build_number": 2}, ""},
+		{"no linux build", map[string]any{"platform": "linux", "arch": "x86_64"}, ""},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			s.body["key"], s.body["deviceId"] = key, "mac"
+			if _, ok := s.body["current_build_number"]; !ok {
+				s.body["current_build_number"] = 1
+			}
+			check := sendTestAppCheck(t, router, s.body)
+			var got string
+			if check.Update != nil {
+				got = check.Update.DownloadURL
+			}
+			if s.expected == "" {
+				if got != "" {
+					t.Fatalf("expected no update, got %s", got)
+				}
+				return
+			}
+			if !strings.Contains(got, "/"+s.expected+"/") {
+				t.Fatalf("expected the artifact %s, got %q", s.expected, got)
+			}
+		})
+	}
+
+	rec := sendTestRequest(router, http.MethodPost, "/api/v1/app_check", map[string]any{"key": key, "deviceId": "mac", "arch": "ppc"}, nil)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,103 @@
+package hooks
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// defaultGracePeriod is how long a device may stay licensed offline
+// before it has to reach /api/v1/app_check again.
+const defaultGracePeriod = 30 * 24 * time.Hour
+
+var errNoSigningKey = errors.New("no license signing key configured")
+
+// licenseCertificate is the payload the desktop app verifies offline with its embedded public keys.
+type licenseCertificate struct {
+	Version   int    `json:"v"`
+	KeyID     string `json:"kid"`
+	LicenseID string `json:"lid"`
+	Tier      string `json:"tier"`
+	DeviceID  string `json:"did"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"` // end of the offline grace period
+
+	// UpdatesUntil is the updates_expire_at of the license, builds released
+	// after it must not unlock the paid features. Omitted for lifetime updates.
+	UpdatesUntil int64 `json:"upd,omitempty"`
+}
+
+// licenseSigningKey reads LICENSE_SIGNING_KEY, formatted as "<key id>:<base64 ed25519 key>".
+// The key may be either the 32 byte seed or the 64 byte private key. Rotating the key
+// only needs a new key id, the app keeps the public keys of all ids it trusts.
+func licenseSigningKey() (string, ed25519.PrivateKey, error) {
+	raw := strings.TrimSpace(os.Getenv("LICENSE_SIGNING_KEY"))
+	if raw == "" {
+		return "", nil, errNoSigningKey
+	}
+
+	keyID, encoded, ok := strings.Cut(raw, ":")
+	if !ok || keyID == "" {
+		return "", nil, errors.New("LICENSE_SIGNING_KEY must be formatted as <key id>:<base64 key>")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", nil, fmt.Errorf("invalid LICENSE_SIGNING_KEY: %w", err)
+	}
+
+	switch len(decoded) {
+	case ed25519.SeedSize:
+		return keyID, ed25519.NewKeyFromSeed(decoded), nil
+	case ed25519.PrivateKeySize:
+		return keyID, ed25519.PrivateKey(decoded), nil
+	}
+	return "", nil, fmt.Errorf("invalid LICENSE_SIGNING_KEY length %d", len(decoded))
+}
+
+// licenseGracePeriod reads LICENSE_GRACE_PERIOD (a Go duration, e.g. "720h").
+func licenseGracePeriod() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("LICENSE_GRACE_PERIOD")); err == nil && d > 0 {
+		return d
+	}
+	return defaultGracePeriod
+}
+
+// issueLicenseCertificate signs a certificate binding the license to a device.
+// The token is "<base64url payload>.<base64url signature>", the signature covering the encoded payload.
+func issueLicenseCertificate(license *core.Record, deviceID string, now time.Time) (string, error) {
+	keyID, key, err := licenseSigningKey()
+	if err != nil {
+		return "", err
+	}
+
+	certificate := licenseCertificate{
+		Version:   1,
+		KeyID:     keyID,
+		LicenseID: license.Id,
+		Tier:      license.GetString("tier"),
+		DeviceID:  deviceID,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(licenseGracePeriod()).Unix(),
+	}
+	if updatesExpireAt := license.GetDateTime("updates_expire_at"); !updatesExpireAt.IsZero() {
+		certificate.UpdatesUntil = updatesExpireAt.Unix()
+	}
+
+	payload, err := json.Marshal(certificate)
+	if err != nil {
+		return "", err
+	}
+
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	signature := ed25519.Sign(key, []byte(encoded))
+
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,113 @@
+package hooks
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// verifyTestCertificate checks the certificate the way the desktop app does and returns its payload.
+func verifyTestCertificate(t *testing.T, token string, publicKey ed25519.PublicKey) licenseCertificate {
+	t.Helper()
+
+	encoded, encodedSignature, ok := strings.Cut(token, ".")
+	if !ok {
+		t.Fatalf("malformed certificate %q", token)
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ed25519.Verify(publicKey, []byte(encoded), signature) {
+		t.Fatal("the certificate signature doesn't verify against the public key")
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var certificate licenseCertificate
+	if err := json.Unmarshal(payload, &certificate); err != nil {
+		t.Fatal(err)
+	}
+	return certificate
+}
+
+func TestActivateIssuesVerifiableCertificate(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	updatesExpireAt := time.Now().Add(365 * 24 * time.Hour).Truncate(time.Second)
+	license, key := newTestLicense(t, app, "cert@example.com", map[string]any{
+		"updates_expire_at": updatesExpireAt,
+	})
+
+	var response struct {
+		Status      string `json:"status"`
+		Tier        string `json:"tier"`
+		Certificate string `json:"certificate"`
+	}
+	before := time.Now().Unix()
+	rec := sendTestRequest(router, http.MethodPost, "/api/v1/activate", map[string]any{
+		"email":    "cert@example.com",
+		"key":      key,
+		"deviceId": "device-1",
+	}, nil)
+	decodeTestResponse(t, rec, http.StatusOK, &response)
+	if response.Status != "success" || response.Tier != "pro" {
+		t.Fatalf("unexpected response %+v", response)
+	}
+
+	certificate := verifyTestCertificate(t, response.Certificate, testSigningKey.Public().(ed25519.PublicKey))
+	if certificate.Version != 1 || certificate.KeyID != "test" {
+		t.Fatalf("unexpected version %d or key id %q", certificate.Version, certificate.KeyID)
+	}
+	if certificate.LicenseID != license.Id || certificate.DeviceID != "device-1" || certificate.Tier != "pro" {
+		t.Fatalf("the certificate doesn't bind the license to the device: %+v", certificate)
+	}
+	if certificate.IssuedAt < before || certificate.IssuedAt > time.Now().Unix() {
+		t.Fatalf("unexpected issue time %d", certificate.IssuedAt)
+	}
+	if grace := certificate.ExpiresAt - certificate.IssuedAt; grace != int64(defaultGracePeriod/time.Second) {
+		t.Fatalf("expected the default grace period, got %ds", grace)
+	}
+	if certificate.UpdatesUntil != updatesExpireAt.Unix() {
+		t.Fatalf("expected the updates expiry %d, got %d", updatesExpireAt.Unix(), certificate.UpdatesUntil)
+	}
+}
+
+func TestCertificateRejectsTampering(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+	_, key := newTestLicense(t, app, "tamper@example.com", nil)
+
+	var response struct {
+		Certificate string `json:"certificate"`
+	}
+	rec := sendTestRequest(router, http.MethodPost, "/api/v1/activate", map[string]any{
+		"email":    "tamper@example.com",
+		"key":      key,
+		"deviceId": "device-1",
+	}, nil)
+	decodeTestResponse(t, rec, http.StatusOK, &response)
+
+	encoded, signature, _ := strings.Cut(response.Certificate, ".")
+	payload, _ := base64.RawURLEncoding.DecodeString(encoded)
+	forged := strings.Replace(string(payload), `"did":"device-1"`, `"did":"device-2"`, 1)
+	if forged == string(payload) {
+		t.Fatalf("unexpected payload %s", payload)
+	}
+
+	decodedSignature, _ := base64.RawURLEncoding.DecodeString(signature)
+	forgedEncoded := base64.RawURLEncoding.EncodeToString([]byte(forged))
+	if ed25519.Verify(testSigningKey.Public().(ed25519.PublicKey), []byte(forgedEncoded), decodedSignature) {
+		t.Fatal("a certificate moved to another device must not verify")
+	}
+	if ed25519.Verify(testUpdateKey.Public().(ed25519.PublicKey), []byte(encoded), decodedSignature) {
+		t.Fatal("the certificate must not verify against another key")
+	}
+}
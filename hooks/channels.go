@@ -0,0 +1,69 @@
+package hooks
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+const (
+	channelStable  = "stable"
+	channelBeta    = "beta"
+	channelNightly = "nightly"
+)
+
+// releaseChannels is ordered from the most to the least stable channel.
+// A channel also receives the builds of every channel before it,
+// e.g. beta testers get stable builds too.
+var releaseChannels = []string{channelStable, channelBeta, channelNightly}
+
+// parseReleaseChannel validates a channel name. An empty name is valid and means "not set".
+func parseReleaseChannel(raw string) (string, bool) {
+	channel := strings.ToLower(strings.TrimSpace(raw))
+	if channel == "" {
+		return "", true
+	}
+	for _, c := range releaseChannels {
+		if c == channel {
+			return channel, true
+		}
+	}
+	return "", false
+}
+
+// resolveReleaseChannel picks the channel a client gets updates from. A channel sent
+// by the app wins, then the enrollment of the device, then the one of the license.
+// license and device may be nil.
+func resolveReleaseChannel(requested string, license, device *core.Record) string {
+	if requested != "" {
+		return requested
+	}
+	if device != nil {
+		if channel := device.GetString("update_channel"); channel != "" {
+			return channel
+		}
+	}
+	if license != nil {
+		if channel := license.GetString("update_channel"); channel != "" {
+			return channel
+		}
+	}
+	return channelStable
+}
+
+// channelFilter returns a versions filter matching the builds available on channel.
+func channelFilter(channel string) (string, dbx.Params) {
+	var conditions []string
+	params := dbx.Params{}
+	for i, c := range releaseChannels {
+		name := "channel" + strconv.Itoa(i)
+		conditions = append(conditions, "channel = {:"+name+"}")
+		params[name] = c
+		if c == channel {
+			break
+		}
+	}
+	return "(" + strings.Join(conditions, " || ") + ")", params
+}
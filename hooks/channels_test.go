@@ -0,0 +1,79 @@
+package hooks
+
+import (
+	"net/http"
+	"testing"
+)
+
+func TestParseReleaseChannel(t *testing.T) {
+	scenarios := []struct {
+		raw      string
+		expected string
+		valid    bool
+	}{
+		{"", "", true},
+		{"stable", channelStable, true},
+		{" Beta ", channelBeta, true},
+		{"NIGHTLY", channelNightly, true},
+		{"alpha", "", false},
+	}
+
+	for _, s := range scenarios {
+		channel, ok := parseReleaseChannel(s.raw)
+		if channel != s.expected || ok != s.valid {
+			t.Errorf("%q: expected %q %v, got %q %v", s.raw, s.expected, s.valid, channel, ok)
+		}
+	}
+}
+
+func TestAppCheckChannels(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	for build, channel := range map[int]string{2: channelStable, 3: channelBeta, 4: channelNightly} {
+		newTestArtifact(t, app, newTestVersion(t, app, build, map[string]any{"channel": channel}), platformMacOS, archUniversal, nil)
+	}
+
+	stable, stableKey := newTestLicense(t, app, "stable@example.com", nil)
+	newTestDevice(t, app, stable, "stable-mac")
+	beta, betaKey := newTestLicense(t, app, "beta@example.com", map[string]any{"update_channel": channelBeta})
+	newTestDevice(t, app, beta, "beta-mac")
+	nightlyDevice := newTestDevice(t, app, beta, "nightly-mac")
+	nightlyDevice.Set("update_channel", channelNightly)
+	if err := app.Save(nightlyDevice); err != nil {
+		t.Fatal(err)
+	}
+
+	scenarios := []struct {
+		name     string
+		body     map[string]any
+		expected string
+	}{
+		{"stable license", map[string]any{"key": stableKey, "deviceId": "stable-mac"}, "1.2"},
+		{"stable license on beta", map[string]any{"key": stableKey, "deviceId": "stable-mac", "channel": "beta"}, "1.3"},
+		{"stable license on nightly", map[string]any{"key": stableKey, "deviceId": "stable-mac", "channel": "nightly"}, "1.4"},
+		{"beta license", map[string]any{"key": betaKey, "deviceId": "beta-mac"}, "1.3"},
+		{"nightly device of a beta license", map[string]any{"key": betaKey, "deviceId": "nightly-mac"}, "1.4"},
+		{"the app setting wins", map[string]any{"key": betaKey, "deviceId": "nightly-mac", "channel": "stable"}, "1.2"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			s.body["current_build_number"] = 1
+			check := sendTestAppCheck(t, router, s.body)
+			if got := check.updateVersion(); got != s.expected {
+				t.Fatalf("expected the update %q, got %q", s.expected, got)
+			}
+		})
+	}
+
+	// Stable builds newer than the beta ones reach beta testers too.
+	newTestArtifact(t, app, newTestVersion(t, app, 5, nil), platformMacOS, archUniversal, nil)
+	check := sendTestAppCheck(t, router, map[string]any{"current_build_number": 3, "key": betaKey, "deviceId": "beta-mac"})
+	if check.updateVersion() != "1.5" || check.Update.Channel != channelStable {
+		t.Fatalf("expected the stable update 1.5, got %+v", check.Update)
+	}
+
+	rec := sendTestRequest(router, http.MethodPost, "/api/v1/app_check", map[string]any{"key": stableKey, "deviceId": "stable-mac", "channel": "alpha"}, nil)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+}
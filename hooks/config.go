@@ -0,0 +1,129 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// All settings come from env vars and are read where they are used, so they
+// can be changed with a restart only. validateConfig checks them once on
+// startup so a typo fails the server right away instead of on the first request.
+//
+//	LICENSE_KEY_SECRET          required, see keys.go
+//	LICENSE_SIGNING_KEY         required, see certificate.go
+//	LICENSE_GRACE_PERIOD        optional Go duration
+//	LICENSE_UPDATES_PERIOD      optional Go duration, see entitlement.go
+//	DODO_WEBHOOK_SECRET         optional, comma separated "whsec_" secrets
+//	STRIPE_WEBHOOK_SECRET       optional, comma separated secrets
+//	UPDATE_SIGNING_PUBLIC_KEYS  optional, comma separated "<key id>:<base64 key>", see artifacts.go
+//	DEACTIVATION_COOLDOWN       optional Go duration
+//	DEACTIVATION_MONTHLY_LIMIT  optional integer
+//	RATE_LIMIT_<NAME>           optional "<burst>/<period>", see ratelimit.go
+//	PB_PUBLIC_URL               optional public base URL, e.g. https://api.example.com
+//	MANAGE_DEVICES_URL          optional page linked from the lost license email, defaults to PORTAL_URL
+//	PORTAL_URL                  optional customer portal page the magic links open, see portal.go
+
+// validateConfig returns all configuration problems at once.
+func validateConfig() error {
+	var errs []error
+
+	if _, err := licenseKeySecret(); err != nil {
+		errs = append(errs, err)
+	}
+	if _, _, err := licenseSigningKey(); err != nil {
+		if errors.Is(err, errNoSigningKey) {
+			err = errors.New("LICENSE_SIGNING_KEY must be set")
+		}
+		errs = append(errs, err)
+	}
+
+	for _, name := range []string{"LICENSE_GRACE_PERIOD", "LICENSE_UPDATES_PERIOD", "DEACTIVATION_COOLDOWN"} {
+		if raw := os.Getenv(name); raw != "" {
+			if d, err := time.ParseDuration(raw); err != nil || d < 0 {
+				errs = append(errs, fmt.Errorf("%s must be a valid duration, e.g. 24h", name))
+			}
+		}
+	}
+	if raw := os.Getenv("DEACTIVATION_MONTHLY_LIMIT"); raw != "" {
+		if n, err := strconv.Atoi(raw); err != nil || n < 0 {
+			errs = append(errs, errors.New("DEACTIVATION_MONTHLY_LIMIT must be a non-negative integer"))
+		}
+	}
+
+	if len(envSecrets("DODO_WEBHOOK_SECRET")) > 0 {
+		if _, err := webhookSecrets("DODO_WEBHOOK_SECRET"); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	// optional on startup, but versions can't be saved without them
+	if len(envSecrets("UPDATE_SIGNING_PUBLIC_KEYS")) > 0 {
+		if _, err := updatePublicKeys(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for name := range defaultRateQuotas {
+		envName := "RATE_LIMIT_" + strings.ToUpper(name)
+		if raw := os.Getenv(envName); raw != "" {
+			if _, err := parseRateQuota(raw); err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", envName, err))
+			}
+		}
+	}
+
+	for _, name := range []string{"PB_PUBLIC_URL", "MANAGE_DEVICES_URL", "PORTAL_URL"} {
+		if raw := os.Getenv(name); raw != "" {
+			if u, err := url.Parse(raw); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				errs = append(errs, fmt.Errorf("%s must be an absolute http(s) URL", name))
+			}
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// defaultAppURL is the Application URL PocketBase ships with.
+const defaultAppURL = "http://localhost:8090"
+
+// configuredBaseURL returns PB_PUBLIC_URL, or else the Application URL from the
+// admin settings. It is empty when neither is set.
+func configuredBaseURL(app core.App) string {
+	if baseURL := os.Getenv("PB_PUBLIC_URL"); baseURL != "" {
+		return strings.TrimRight(baseURL, "/")
+	}
+
+	// Ignore the stock value of a fresh install, it is never the public URL.
+	if appURL := app.Settings().Meta.AppURL; appURL != "" && appURL != defaultAppURL {
+		return strings.TrimRight(appURL, "/")
+	}
+
+	return ""
+}
+
+// publicBaseURL returns the URL clients reach the server on, used to build absolute links.
+// It prefers the configured base URL and falls back to the host the request was sent to.
+func publicBaseURL(e *core.RequestEvent) string {
+	if baseURL := configuredBaseURL(e.App); baseURL != "" {
+		return baseURL
+	}
+
+	scheme := "http"
+	if e.IsTLS() || e.Request.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + e.Request.Host
+}
+
+// recordFileURL builds the absolute download URL of a file stored on a record,
+// e.g. https://api.example.com/api/files/<collection id>/<record id>/<file name>.
+func recordFileURL(e *core.RequestEvent, record *core.Record, filename string) (string, error) {
+	return url.JoinPath(publicBaseURL(e), "api", "files", record.BaseFilesPath(), filename)
+}
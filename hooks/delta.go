@@ -0,0 +1,316 @@
+package hooks
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Delta packages rebuild a new build's archive from the archive the client already has.
+// Zip archives keep unchanged entries byte for byte, so an rsync style block match
+// against the old archive removes most of the download.
+//
+// Format, everything after the magic being a zlib stream:
+//
+//	"CCDELTA1"
+//	uvarint source size, 32 byte source sha256
+//	uvarint target size, 32 byte target sha256
+//	ops until deltaOpEnd:
+//	  deltaOpCopy   uvarint offset, uvarint length   copy from the source
+//	  deltaOpInsert uvarint length, bytes            literal data
+//	  deltaOpEnd
+//
+// The result is verified against the target digest, and clients still verify it
+// against the signature_eddsa of the full artifact before installing.
+//
+// This format is our own, Sparkle can't apply it. Deltas are only offered in the
+// "delta" object of the /api/v1/app_check response, to clients that send the
+// build they run, and never as sparkle:deltas in the appcast. A client that
+// fails to download or apply a delta falls back to the full download_url.
+const (
+	deltaMagic     = "CCDELTA1"
+	deltaBlockSize = 64
+
+	// deltaMaxTargetSize is the largest artifact a delta may build, the maxSize
+	// of the artifacts file field. The target size is read from the delta
+	// before anything is verified, so it is checked before allocating.
+	deltaMaxTargetSize = 1 << 30
+
+	// deltaMaxCandidates bounds the source offsets tried per block hash,
+	// so highly repetitive input can't make generation quadratic.
+	deltaMaxCandidates = 8
+)
+
+const (
+	deltaOpEnd byte = iota
+	deltaOpCopy
+	deltaOpInsert
+)
+
+var errDeltaMismatch = errors.New("delta doesn't apply to this source")
+
+// rollingHash is the rsync weak checksum over a window of deltaBlockSize bytes.
+type rollingHash struct {
+	a, b uint32
+}
+
+func newRollingHash(window []byte) rollingHash {
+	var h rollingHash
+	for i, c := range window {
+		h.a += uint32(c)
+		h.b += uint32(len(window)-i) * uint32(c)
+	}
+	return h
+}
+
+// roll moves the window one byte forward.
+func (h *rollingHash) roll(out, in byte) {
+	h.a += uint32(in) - uint32(out)
+	h.b += h.a - deltaBlockSize*uint32(out)
+}
+
+func (h rollingHash) sum() uint32 {
+	return h.a&0xffff | h.b<<16
+}
+
+type deltaWriter struct {
+	w   *zlib.Writer
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (d *deltaWriter) uvarint(v int) {
+	n := binary.PutUvarint(d.buf[:], uint64(v))
+	d.w.Write(d.buf[:n])
+}
+
+func (d *deltaWriter) insert(data []byte) {
+	if len(data) == 0 {
+		return
+	}
+	d.w.Write([]byte{deltaOpInsert})
+	d.uvarint(len(data))
+	d.w.Write(data)
+}
+
+func (d *deltaWriter) copy(offset, length int) {
+	d.w.Write([]byte{deltaOpCopy})
+	d.uvarint(offset)
+	d.uvarint(length)
+}
+
+// createDelta encodes target as copies from source plus literal data.
+func createDelta(source, target []byte) ([]byte, error) {
+	var out bytes.Buffer
+	out.WriteString(deltaMagic)
+
+	zw, err := zlib.NewWriterLevel(&out, zlib.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	d := &deltaWriter{w: zw}
+
+	sourceSum, targetSum := sha256.Sum256(source), sha256.Sum256(target)
+	d.uvarint(len(source))
+	zw.Write(sourceSum[:])
+	d.uvarint(len(target))
+	zw.Write(targetSum[:])
+
+	// index the source blocks by their weak hash
+	blocks := map[uint32][]int{}
+	for offset := 0; offset+deltaBlockSize <= len(source); offset += deltaBlockSize {
+		sum := newRollingHash(source[offset : offset+deltaBlockSize]).sum()
+		if len(blocks[sum]) < deltaMaxCandidates {
+			blocks[sum] = append(blocks[sum], offset)
+		}
+	}
+
+	literalStart := 0
+	i := 0
+	var h rollingHash
+	if len(target) >= deltaBlockSize {
+		h = newRollingHash(target[:deltaBlockSize])
+	}
+	for i+deltaBlockSize <= len(target) {
+		window := target[i : i+deltaBlockSize]
+
+		bestOffset, bestLength := -1, 0
+		for _, offset := range blocks[h.sum()] {
+			if !bytes.Equal(source[offset:offset+deltaBlockSize], window) {
+				continue
+			}
+			length := deltaBlockSize
+			for offset+length < len(source) && i+length < len(target) && source[offset+length] == target[i+length] {
+				length++
+			}
+			if length > bestLength {
+				bestOffset, bestLength = offset, length
+			}
+		}
+
+		if bestOffset < 0 {
+			if i+deltaBlockSize < len(target) {
+				h.roll(target[i], target[i+deltaBlockSize])
+			}
+			i++
+			continue
+		}
+
+		// extend the match backwards into the pending literal data
+		start := i
+		for start > literalStart && bestOffset > 0 && source[bestOffset-1] == target[start-1] {
+			start--
+			bestOffset--
+			bestLength++
+		}
+
+		d.insert(target[literalStart:start])
+		d.copy(bestOffset, bestLength)
+
+		i = start + bestLength
+		literalStart = i
+		if i+deltaBlockSize <= len(target) {
+			h = newRollingHash(target[i : i+deltaBlockSize])
+		}
+	}
+	d.insert(target[literalStart:])
+
+	zw.Write([]byte{deltaOpEnd})
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// applyDelta rebuilds the target from source and a delta created by createDelta.
+func applyDelta(source, delta []byte) ([]byte, error) {
+	if !bytes.HasPrefix(delta, []byte(deltaMagic)) {
+		return nil, errors.New("not a delta package")
+	}
+
+	zr, err := zlib.NewReader(bytes.NewReader(delta[len(deltaMagic):]))
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	r := bufio.NewReader(zr)
+
+	readSum := func() ([]byte, error) {
+		sum := make([]byte, sha256.Size)
+		_, err := io.ReadFull(r, sum)
+		return sum, err
+	}
+
+	sourceSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	sourceSum, err := readSum()
+	if err != nil {
+		return nil, err
+	}
+	actualSourceSum := sha256.Sum256(source)
+	if sourceSize != uint64(len(source)) || !bytes.Equal(sourceSum, actualSourceSum[:]) {
+		return nil, errDeltaMismatch
+	}
+
+	targetSize, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+	if targetSize > deltaMaxTargetSize {
+		return nil, fmt.Errorf("delta target size %d exceeds the limit of %d bytes", targetSize, deltaMaxTargetSize)
+	}
+	targetSum, err := readSum()
+	if err != nil {
+		return nil, err
+	}
+
+	target := make([]byte, 0, targetSize)
+	for {
+		op, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+
+		switch op {
+		case deltaOpEnd:
+			actualTargetSum := sha256.Sum256(target)
+			if uint64(len(target)) != targetSize || !bytes.Equal(targetSum, actualTargetSum[:]) {
+				return nil, errors.New("delta produced a corrupted target")
+			}
+			return target, nil
+		case deltaOpCopy:
+			offset, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if offset > uint64(len(source)) || length > uint64(len(source))-offset {
+				return nil, errors.New("delta copies outside of the source")
+			}
+			if length > targetSize-uint64(len(target)) {
+				return nil, errors.New("delta copies past the target size")
+			}
+			target = append(target, source[offset:offset+length]...)
+		case deltaOpInsert:
+			length, err := binary.ReadUvarint(r)
+			if err != nil {
+				return nil, err
+			}
+			if length > targetSize-uint64(len(target)) {
+				return nil, errors.New("delta inserts past the target size")
+			}
+			chunk := make([]byte, length)
+			if _, err := io.ReadFull(r, chunk); err != nil {
+				return nil, err
+			}
+			target = append(target, chunk...)
+		default:
+			return nil, fmt.Errorf("unknown delta op %d", op)
+		}
+	}
+}
+
+// deleteArtifactDeltas deletes the deltas building artifact and the ones starting from it.
+func deleteArtifactDeltas(app core.App, artifact *core.Record) error {
+	deltas, err := app.FindRecordsByFilter(
+		"deltas",
+		"artifact = {:artifact} || from_artifact = {:artifact}",
+		"", 0, 0,
+		dbx.Params{"artifact": artifact.Id},
+	)
+	if err != nil {
+		return err
+	}
+
+	for _, delta := range deltas {
+		if err := app.Delete(delta); err != nil {
+			return err
+		}
+	}
+	if len(deltas) > 0 {
+		app.Logger().Info("Deleted the deltas of a replaced artifact", "artifact", artifact.Id, "deltas", len(deltas))
+	}
+	return nil
+}
+
+// findArtifactDelta returns the delta that updates fromBuild to artifact, or nil if none was generated.
+func findArtifactDelta(app core.App, artifact *core.Record, fromBuild int) (*core.Record, error) {
+	deltas, err := app.FindAllRecords("deltas", dbx.HashExp{"artifact": artifact.Id, "from_build": fromBuild})
+	if err != nil || len(deltas) == 0 {
+		return nil, err
+	}
+	return deltas[0], nil
+}
@@ -0,0 +1,188 @@
+package hooks
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/spf13/cobra"
+)
+
+// deltaMinSavings skips deltas that wouldn't save at least this share of the full download.
+const deltaMinSavings = 0.1
+
+// NewDeltaCommand creates the "deltas" command used to generate delta update packages, e.g.
+//
+//	cc-hub deltas generate 42              # from the 3 previous published builds
+//	cc-hub deltas generate 42 --from 40,41
+func NewDeltaCommand(app core.App) *cobra.Command {
+	command := &cobra.Command{
+		Use:   "deltas",
+		Short: "Manage the delta update packages",
+	}
+
+	command.AddCommand(deltaGenerateCommand(app))
+
+	return command
+}
+
+func deltaGenerateCommand(app core.App) *cobra.Command {
+	var fromBuilds []int
+	var previous int
+	var force bool
+
+	command := &cobra.Command{
+		Use:          "generate <to_build>",
+		Short:        "Generates the delta packages from older builds to a build, for each of its artifacts",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(command *cobra.Command, args []string) error {
+			toBuild, err := strconv.Atoi(args[0])
+			if err != nil {
+				return fmt.Errorf("invalid build number %q", args[0])
+			}
+
+			target, err := app.FindFirstRecordByFilter("versions", "build_number = {:build}", dbx.Params{"build": toBuild})
+			if err != nil {
+				return fmt.Errorf("no version with build number %d", toBuild)
+			}
+
+			var sources []*core.Record
+			if len(fromBuilds) > 0 {
+				for _, fromBuild := range fromBuilds {
+					source, err := app.FindFirstRecordByFilter("versions", "build_number = {:build}", dbx.Params{"build": fromBuild})
+					if err != nil {
+						return fmt.Errorf("no version with build number %d", fromBuild)
+					}
+					sources = append(sources, source)
+				}
+			} else {
+				sources, err = app.FindRecordsByFilter(
+					"versions",
+					"is_published = true && build_number < {:build}",
+					"-build_number",
+					previous, 0,
+					dbx.Params{"build": toBuild},
+				)
+				if err != nil {
+					return err
+				}
+			}
+
+			artifacts, err := app.FindAllRecords("artifacts", dbx.HashExp{"version": target.Id})
+			if err != nil {
+				return err
+			}
+			if len(artifacts) == 0 {
+				return fmt.Errorf("build %d has no artifacts", toBuild)
+			}
+
+			for _, artifact := range artifacts {
+				for _, source := range sources {
+					if err := generateArtifactDelta(command, app, artifact, source, toBuild, force); err != nil {
+						return err
+					}
+				}
+			}
+
+			return nil
+		},
+	}
+
+	command.Flags().IntSliceVar(&fromBuilds, "from", nil, "the builds to generate deltas from (default: the previous published builds)")
+	command.Flags().IntVar(&previous, "previous", 3, "how many previous published builds to generate deltas from")
+	command.Flags().BoolVar(&force, "force", false, "regenerate the deltas that already exist")
+
+	return command
+}
+
+// generateArtifactDelta stores the delta from the artifact of source with the same OS and architecture to artifact.
+func generateArtifactDelta(command *cobra.Command, app core.App, artifact, source *core.Record, toBuild int, force bool) error {
+	fromBuild := source.GetInt("build_number")
+	label := fmt.Sprintf("%s/%s %d -> %d", artifact.GetString("os"), artifact.GetString("arch"), fromBuild, toBuild)
+
+	fromArtifact, err := app.FindFirstRecordByFilter(
+		"artifacts",
+		"version = {:version} && os = {:os} && arch = {:arch}",
+		dbx.Params{"version": source.Id, "os": artifact.GetString("os"), "arch": artifact.GetString("arch")},
+	)
+	if err != nil {
+		command.Printf("%s: skipped, build %d has no matching artifact\n", label, fromBuild)
+		return nil
+	}
+
+	existing, err := findArtifactDelta(app, artifact, fromBuild)
+	if err != nil {
+		return err
+	}
+	if existing != nil {
+		if !force {
+			command.Printf("%s: already exists\n", label)
+			return nil
+		}
+		if err := app.Delete(existing); err != nil {
+			return err
+		}
+	}
+
+	sourceData, err := readArtifactFile(app, fromArtifact)
+	if err != nil {
+		return err
+	}
+	targetData, err := readArtifactFile(app, artifact)
+	if err != nil {
+		return err
+	}
+
+	delta, err := createDelta(sourceData, targetData)
+	if err != nil {
+		return err
+	}
+
+	// Never store a delta that doesn't rebuild the exact artifact.
+	rebuilt, err := applyDelta(sourceData, delta)
+	if err != nil {
+		return fmt.Errorf("%s: %w", label, err)
+	}
+	if len(rebuilt) != len(targetData) {
+		return errors.New(label + ": the delta doesn't rebuild the artifact")
+	}
+
+	if float64(len(delta)) > float64(len(targetData))*(1-deltaMinSavings) {
+		command.Printf("%s: skipped, the delta is %d of %d bytes\n", label, len(delta), len(targetData))
+		return nil
+	}
+
+	collection, err := app.FindCollectionByNameOrId("deltas")
+	if err != nil {
+		return err
+	}
+
+	file, err := filesystem.NewFileFromBytes(delta, fmt.Sprintf(
+		"%s_%s_%d_%d.ccdelta", artifact.GetString("os"), artifact.GetString("arch"), fromBuild, toBuild,
+	))
+	if err != nil {
+		return err
+	}
+
+	sum := sha256.Sum256(delta)
+	record := core.NewRecord(collection)
+	record.Set("artifact", artifact.Id)
+	record.Set("from_artifact", fromArtifact.Id)
+	record.Set("from_build", fromBuild)
+	record.Set("to_build", toBuild)
+	record.Set("file", file)
+	record.Set("size", len(delta))
+	record.Set("sha256", hex.EncodeToString(sum[:]))
+	if err := app.Save(record); err != nil {
+		return err
+	}
+
+	command.Printf("%s: %d of %d bytes (%.0f%%)\n", label, len(delta), len(targetData), 100*float64(len(delta))/float64(len(targetData)))
+	return nil
+}
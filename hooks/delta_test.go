@@ -0,0 +1,188 @@
+package hooks
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha256"
+	"errors"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// mutate returns a copy of data with a few regions changed, inserted and removed,
+// the way a new build differs from the previous one.
+func mutate(rng *rand.Rand, data []byte) []byte {
+	out := bytes.Clone(data)
+	for range 20 {
+		if len(out) == 0 {
+			break
+		}
+		at := rng.Intn(len(out))
+		n := min(rng.Intn(512)+1, len(out)-at)
+		switch rng.Intn(3) {
+		case 0:
+			rng.Read(out[at : at+n])
+		case 1:
+			extra := make([]byte, n)
+			rng.Read(extra)
+			out = append(out[:at], append(extra, out[at:]...)...)
+		case 2:
+			out = append(out[:at], out[at+n:]...)
+		}
+	}
+	return out
+}
+
+func TestDeltaRoundTrip(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+
+	random := make([]byte, 256<<10)
+	rng.Read(random)
+	small := []byte("a short build")
+
+	scenarios := []struct {
+		name   string
+		source []byte
+		target []byte
+	}{
+		{"mutated copy", random, mutate(rng, random)},
+		{"identical", random, random},
+		{"unrelated", random, bytes.Repeat([]byte{7}, 4096)},
+		{"empty source", nil, small},
+		{"empty target", small, nil},
+		{"both empty", nil, nil},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			delta, err := createDelta(s.source, s.target)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := applyDelta(s.source, delta)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got, s.target) {
+				t.Fatalf("expected the %d bytes target, got %d bytes that differ", len(s.target), len(got))
+			}
+		})
+	}
+}
+
+func TestDeltaSmallerThanTarget(t *testing.T) {
+	rng := rand.New(rand.NewSource(2))
+
+	source := make([]byte, 256<<10)
+	rng.Read(source)
+	target := mutate(rng, source)
+
+	delta, err := createDelta(source, target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(delta) > len(target)/4 {
+		t.Fatalf("expected the delta of a mutated copy to be small, got %d bytes for a %d bytes target", len(delta), len(target))
+	}
+}
+
+func TestApplyDeltaMismatchedSource(t *testing.T) {
+	rng := rand.New(rand.NewSource(3))
+
+	source := make([]byte, 64<<10)
+	rng.Read(source)
+	delta, err := createDelta(source, mutate(rng, source))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sameSize := bytes.Clone(source)
+	sameSize[len(sameSize)/2] ^= 0xff
+
+	scenarios := []struct {
+		name   string
+		source []byte
+	}{
+		{"different content", sameSize},
+		{"different size", source[1:]},
+		{"empty", nil},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if _, err := applyDelta(s.source, delta); !errors.Is(err, errDeltaMismatch) {
+				t.Fatalf("expected %v, got %v", errDeltaMismatch, err)
+			}
+		})
+	}
+}
+
+func TestApplyDeltaInvalid(t *testing.T) {
+	for _, delta := range [][]byte{nil, []byte("CCDELTA1"), []byte("not a delta at all")} {
+		if _, err := applyDelta(nil, delta); err == nil {
+			t.Fatalf("expected %q to be rejected", delta)
+		}
+	}
+}
+
+// rawDelta encodes a delta for source with the given header target size and ops.
+func rawDelta(source []byte, targetSize int, ops func(d *deltaWriter)) []byte {
+	var out bytes.Buffer
+	out.WriteString(deltaMagic)
+	zw := zlib.NewWriter(&out)
+	d := &deltaWriter{w: zw}
+
+	sourceSum := sha256.Sum256(source)
+	d.uvarint(len(source))
+	zw.Write(sourceSum[:])
+	d.uvarint(targetSize)
+	zw.Write(make([]byte, sha256.Size))
+	ops(d)
+	zw.Write([]byte{deltaOpEnd})
+	zw.Close()
+
+	return out.Bytes()
+}
+
+func TestApplyDeltaTargetSize(t *testing.T) {
+	source := []byte("the previous build of the app")
+
+	scenarios := []struct {
+		name     string
+		delta    []byte
+		expected string
+	}{
+		{
+			"huge target size",
+			rawDelta(source, 1<<62, func(d *deltaWriter) {}),
+			"exceeds the limit",
+		},
+		{
+			"copies past the target size",
+			rawDelta(source, 10, func(d *deltaWriter) {
+				for range 1000 {
+					d.copy(0, len(source))
+				}
+			}),
+			"copies past the target size",
+		},
+		{
+			"inserts past the target size",
+			rawDelta(source, 10, func(d *deltaWriter) {
+				d.insert(bytes.Repeat([]byte{1}, 11))
+			}),
+			"inserts past the target size",
+		},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			_, err := applyDelta(source, s.delta)
+			if err == nil || !strings.Contains(err.Error(), s.expected) {
+				t.Fatalf("expected an error containing %q, got %v", s.expected, err)
+			}
+		})
+	}
+}
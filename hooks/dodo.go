@@ -0,0 +1,127 @@
+package hooks
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// dodoProcessor handles Dodo Payments webhooks, signed with the
+// Standard Webhooks secrets from DODO_WEBHOOK_SECRET.
+type dodoProcessor struct{}
+
+// dodoCustomer is the customer object embedded in Dodo payloads.
+type dodoCustomer struct {
+	Email string `json:"email"`
+	Name  string `json:"name"`
+}
+
+// dodoBilling is the billing address embedded in Dodo payments.
+type dodoBilling struct {
+	Street  string `json:"street"`
+	City    string `json:"city"`
+	State   string `json:"state"`
+	Zipcode string `json:"zipcode"`
+	Country string `json:"country"`
+}
+
+// dodoPayment is the "data" object of Dodo payment events.
+type dodoPayment struct {
+	PaymentID   string       `json:"payment_id"`
+	Customer    dodoCustomer `json:"customer"`
+	TotalAmount int64        `json:"total_amount"` // in the smallest currency unit, tax included
+	Tax         int64        `json:"tax"`
+	Currency    string       `json:"currency"`
+	Billing     dodoBilling  `json:"billing"`
+}
+
+// dodoRefund is the "data" object of Dodo refund events.
+type dodoRefund struct {
+	PaymentID string `json:"payment_id"`
+	IsPartial bool   `json:"is_partial"`
+	Reason    string `json:"reason"`
+}
+
+// dodoDispute is the "data" object of Dodo dispute events.
+type dodoDispute struct {
+	PaymentID string `json:"payment_id"`
+	Remarks   string `json:"remarks"`
+}
+
+func (dodoProcessor) Name() string {
+	return "dodo"
+}
+
+func (dodoProcessor) VerifyRequest(header http.Header, body []byte, now time.Time) error {
+	secrets, err := webhookSecrets("DODO_WEBHOOK_SECRET")
+	if err != nil {
+		return err
+	}
+	return verifyStandardWebhook(header, body, secrets, now)
+}
+
+func (dodoProcessor) ParseEvent(body []byte) (*processorEvent, error) {
+	envelope := struct {
+		Type string          `json:"type"`
+		Data json.RawMessage `json:"data"`
+	}{}
+	payload, err := decodeProcessorEvent(body, &envelope)
+	if err != nil {
+		return nil, err
+	}
+	return &processorEvent{Type: envelope.Type, Data: envelope.Data, Payload: payload}, nil
+}
+
+func (dodoProcessor) Purchase(event *processorEvent) (*purchase, error) {
+	if event.Type != "payment.succeeded" {
+		return nil, nil
+	}
+
+	payment := dodoPayment{}
+	if err := json.Unmarshal(event.Data, &payment); err != nil {
+		return nil, err
+	}
+
+	return &purchase{
+		ProcessorID:   payment.PaymentID,
+		CustomerEmail: payment.Customer.Email,
+		CustomerName:  payment.Customer.Name,
+		Amount:        payment.TotalAmount,
+		Tax:           payment.Tax,
+		Currency:      payment.Currency,
+		BillingAddress: billingAddress{
+			Line1:      payment.Billing.Street,
+			City:       payment.Billing.City,
+			State:      payment.Billing.State,
+			PostalCode: payment.Billing.Zipcode,
+			Country:    payment.Billing.Country,
+		},
+	}, nil
+}
+
+func (dodoProcessor) Reversal(event *processorEvent) (*reversal, error) {
+	switch event.Type {
+	case "refund.succeeded":
+		refund := dodoRefund{}
+		if err := json.Unmarshal(event.Data, &refund); err != nil {
+			return nil, err
+		}
+		if refund.IsPartial {
+			return nil, nil
+		}
+		return &reversal{Kind: reversalRefund, ProcessorID: refund.PaymentID, Reason: refund.Reason}, nil
+	case "dispute.opened", "dispute.lost", "dispute.won":
+		dispute := dodoDispute{}
+		if err := json.Unmarshal(event.Data, &dispute); err != nil {
+			return nil, err
+		}
+		kind := map[string]reversalKind{
+			"dispute.opened": reversalDisputeOpened,
+			"dispute.lost":   reversalDisputeLost,
+			"dispute.won":    reversalDisputeWon,
+		}[event.Type]
+		return &reversal{Kind: kind, ProcessorID: dispute.PaymentID, Reason: dispute.Remarks}, nil
+	}
+
+	return nil, nil
+}
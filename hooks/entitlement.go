@@ -0,0 +1,60 @@
+package hooks
+
+import (
+	"os"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Licenses with an updates_expire_at are only entitled to the builds released
+// (published_at) before that date, they keep running those builds forever.
+// An empty updates_expire_at means lifetime updates, which is what every
+// license issued before the field existed gets.
+
+// licenseUpdatesPeriod reads LICENSE_UPDATES_PERIOD (a Go duration, e.g. "8760h"),
+// the update entitlement of newly issued licenses. Zero means lifetime updates.
+func licenseUpdatesPeriod() time.Duration {
+	if d, err := time.ParseDuration(os.Getenv("LICENSE_UPDATES_PERIOD")); err == nil && d > 0 {
+		return d
+	}
+	return 0
+}
+
+// registerVersionHooks dates versions when they get published, unless an admin set published_at.
+func registerVersionHooks(app core.App) {
+	setPublishedAt := func(e *core.RecordEvent) error {
+		if e.Record.GetBool("is_published") && e.Record.GetDateTime("published_at").IsZero() {
+			e.Record.Set("published_at", types.NowDateTime())
+		}
+		return e.Next()
+	}
+	app.OnRecordCreate("versions").BindFunc(setPublishedAt)
+	app.OnRecordUpdate("versions").BindFunc(setPublishedAt)
+}
+
+// versionReleasedAt is when version was published, falling back to its creation
+// for the versions saved without the hook above.
+func versionReleasedAt(version *core.Record) types.DateTime {
+	publishedAt := version.GetDateTime("published_at")
+	if publishedAt.IsZero() {
+		return version.GetDateTime("created")
+	}
+	return publishedAt
+}
+
+// licenseEntitledTo reports whether license covers the updates to version.
+// Clients without an active license on their device (no key, a revoked or held
+// license, a device that isn't activated) pass nil and aren't entitled to any
+// update. They still get forced updates and yank rollbacks, see handleAppCheck.
+func licenseEntitledTo(license, version *core.Record) bool {
+	if license == nil {
+		return false
+	}
+	expiresAt := license.GetDateTime("updates_expire_at")
+	if expiresAt.IsZero() {
+		return true
+	}
+	return !versionReleasedAt(version).After(expiresAt)
+}
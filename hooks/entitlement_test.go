@@ -0,0 +1,107 @@
+package hooks
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestLicenseEntitledTo(t *testing.T) {
+	licenses := core.NewBaseCollection("licenses")
+	licenses.Fields.Add(&core.DateField{Name: "updates_expire_at"})
+	versions := core.NewBaseCollection("versions")
+	versions.Fields.Add(&core.DateField{Name: "published_at"})
+
+	newLicense := func(updatesExpireAt string) *core.Record {
+		license := core.NewRecord(licenses)
+		license.Set("updates_expire_at", updatesExpireAt)
+		return license
+	}
+	version := core.NewRecord(versions)
+	version.Set("published_at", "2025-06-01 00:00:00.000Z")
+
+	scenarios := []struct {
+		name     string
+		license  *core.Record
+		expected bool
+	}{
+		{"no active license", nil, false},
+		{"lifetime updates", newLicense(""), true},
+		{"released before the expiry", newLicense("2025-07-01 00:00:00.000Z"), true},
+		{"released at the expiry", newLicense("2025-06-01 00:00:00.000Z"), true},
+		{"released after the expiry", newLicense("2025-05-01 00:00:00.000Z"), false},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			if got := licenseEntitledTo(s.license, version); got != s.expected {
+				t.Fatalf("expected %v, got %v", s.expected, got)
+			}
+		})
+	}
+}
+
+func TestAppCheckEntitlement(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	// Build 3 is required by every older build, build 5 is yanked.
+	for build := 2; build <= 6; build++ {
+		fields := map[string]any{"published_at": fmt.Sprintf("2025-%02d-01 00:00:00.000Z", build)}
+		switch build {
+		case 3:
+			fields["min_required_build"] = 3
+		case 5:
+			fields["is_yanked"] = true
+			fields["yank_reason"] = "Crashes on launch"
+		}
+		newTestArtifact(t, app, newTestVersion(t, app, build, fields), platformMacOS, archUniversal, nil)
+	}
+
+	expired, expiredKey := newTestLicense(t, app, "expired@example.com", map[string]any{
+		"updates_expire_at": "2025-03-15 00:00:00.000Z",
+	})
+	newTestDevice(t, app, expired, "expired-mac")
+	revoked, revokedKey := newTestLicense(t, app, "revoked@example.com", map[string]any{"status": "revoked"})
+	newTestDevice(t, app, revoked, "revoked-mac")
+
+	scenarios := []struct {
+		name     string
+		body     map[string]any
+		update   string
+		forced   bool
+		rollback bool
+		upgrade  string
+	}{
+		{"free, up to date", map[string]any{"current_build_number": 4}, "", false, false, ""},
+		{"free, below the minimum", map[string]any{"current_build_number": 1}, "1.3", true, false, ""},
+		{"free, on the yanked build", map[string]any{"current_build_number": 5}, "1.4", true, true, ""},
+		{"unactivated device, below the minimum", map[string]any{"current_build_number": 2, "key": expiredKey, "deviceId": "other-mac"}, "1.3", true, false, ""},
+		{"revoked, below the minimum", map[string]any{"current_build_number": 2, "key": revokedKey, "deviceId": "revoked-mac"}, "1.3", true, false, ""},
+		{"revoked, on the yanked build", map[string]any{"current_build_number": 5, "key": revokedKey, "deviceId": "revoked-mac"}, "1.4", true, true, ""},
+		{"expired, below the minimum", map[string]any{"current_build_number": 1, "key": expiredKey, "deviceId": "expired-mac"}, "1.3", true, false, "1.6"},
+		{"expired, up to date", map[string]any{"current_build_number": 3, "key": expiredKey, "deviceId": "expired-mac"}, "", false, false, "1.6"},
+		{"expired, on the yanked build", map[string]any{"current_build_number": 5, "key": expiredKey, "deviceId": "expired-mac"}, "1.4", true, true, "1.6"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			check := sendTestAppCheck(t, router, s.body)
+			if got := check.updateVersion(); got != s.update {
+				t.Fatalf("expected the update %q, got %q", s.update, got)
+			}
+			if check.Update != nil {
+				if check.Update.ForceUpdate != s.forced {
+					t.Fatalf("expected force_update %v, got %v", s.forced, check.Update.ForceUpdate)
+				}
+				if (check.Update.Rollback != nil) != s.rollback {
+					t.Fatalf("expected rollback %v, got %+v", s.rollback, check.Update.Rollback)
+				}
+			}
+			if got := check.upgradeVersion(); got != s.upgrade {
+				t.Fatalf("expected the upgrade %q, got %q", s.upgrade, got)
+			}
+		})
+	}
+}
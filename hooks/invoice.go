@@ -0,0 +1,281 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+)
+
+// Every fulfilled purchase gets the next invoice number in the same db transaction
+// as its license, so the numbers have no gaps. Invoices are rendered from the
+// "invoice" template of mail.go: its HTML part is the HTML invoice and its text
+// part is laid out as the PDF. The seller details live in that template, override
+// it to change them.
+//
+// The rendered invoice is stored in the transaction "invoice_files" when it is
+// issued and downloads serve that copy, so later template or license edits never
+// change an issued invoice. Issuing is best effort: if the template fails, the
+// license is still issued and the invoice is rendered on its first download.
+
+// invoiceNumberPrefix is prepended to the sequential invoice number, e.g. CC-000042.
+const invoiceNumberPrefix = "CC-"
+
+var errNoInvoice = errors.New("the purchase has no invoice")
+
+// zeroDecimalCurrencies have no minor unit, their amounts are stored in whole units.
+var zeroDecimalCurrencies = map[string]bool{
+	"BIF": true, "CLP": true, "DJF": true, "GNF": true, "JPY": true, "KMF": true,
+	"KRW": true, "MGA": true, "PYG": true, "RWF": true, "UGX": true, "VND": true,
+	"VUV": true, "XAF": true, "XOF": true, "XPF": true,
+}
+
+// billingAddress is the customer address of a purchase, stored as the transaction "billing_address".
+type billingAddress struct {
+	Line1      string `json:"line1,omitempty"`
+	Line2      string `json:"line2,omitempty"`
+	City       string `json:"city,omitempty"`
+	State      string `json:"state,omitempty"`
+	PostalCode string `json:"postal_code,omitempty"`
+	Country    string `json:"country,omitempty"` // ISO 3166-1 alpha-2
+}
+
+// Lines formats the address for the invoice, skipping the empty parts.
+func (a billingAddress) Lines() []string {
+	var lines []string
+	for _, line := range []string{
+		a.Line1,
+		a.Line2,
+		strings.TrimSpace(a.PostalCode + " " + a.City),
+		a.State,
+		a.Country,
+	} {
+		if line != "" {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// invoice is the data of the "invoice" template.
+type invoice struct {
+	Number        string
+	IssuedAt      time.Time
+	PaymentID     string
+	CustomerName  string
+	CustomerEmail string
+	Address       []string
+	Tier          string
+	Subtotal      string
+	Tax           string // empty when no tax was charged
+	Total         string
+}
+
+// renderedInvoice is an invoice in both of its formats.
+type renderedInvoice struct {
+	Number string
+	HTML   []byte
+	PDF    []byte
+}
+
+// nextInvoiceNumber returns the number following the last issued invoice.
+// Callers must save it in the same db transaction they read it in.
+func nextInvoiceNumber(app core.App) (int, error) {
+	var result struct {
+		Number int `db:"number"`
+	}
+	err := app.DB().NewQuery("SELECT COALESCE(MAX([[invoice_number]]), 0) + 1 AS [[number]] FROM {{transactions}}").One(&result)
+	return result.Number, err
+}
+
+func formatInvoiceNumber(number int) string {
+	return fmt.Sprintf("%s%06d", invoiceNumberPrefix, number)
+}
+
+// formatMoney formats an amount in the minor unit of currency, e.g. "12.50 USD".
+func formatMoney(amount int64, currency string) string {
+	if zeroDecimalCurrencies[currency] {
+		return fmt.Sprintf("%d %s", amount, currency)
+	}
+	return fmt.Sprintf("%d.%02d %s", amount/100, amount%100, currency)
+}
+
+// renderInvoice renders the invoice of the purchase that issued license in locale.
+func renderInvoice(app core.App, transaction, license *core.Record, locale string) (*renderedInvoice, error) {
+	number := transaction.GetInt("invoice_number")
+	if number == 0 {
+		return nil, errNoInvoice
+	}
+
+	var address billingAddress
+	if transaction.GetString("billing_address") != "" {
+		if err := transaction.UnmarshalJSONField("billing_address", &address); err != nil {
+			return nil, err
+		}
+	}
+
+	currency := transaction.GetString("currency")
+	total, tax := int64(transaction.GetInt("amount")), int64(transaction.GetInt("tax_amount"))
+	data := invoice{
+		Number:        formatInvoiceNumber(number),
+		IssuedAt:      transaction.GetDateTime("invoiced_at").Time(),
+		PaymentID:     transaction.GetString("processor_id"),
+		CustomerName:  transaction.GetString("user_name"),
+		CustomerEmail: transaction.GetString("user_email"),
+		Address:       address.Lines(),
+		Tier:          license.GetString("tier"),
+		Subtotal:      formatMoney(total-tax, currency),
+		Total:         formatMoney(total, currency),
+	}
+	if tax > 0 {
+		data.Tax = formatMoney(tax, currency)
+	}
+
+	rendered, err := renderEmail(app, "invoice", locale, data)
+	if err != nil {
+		return nil, err
+	}
+
+	pdf, err := renderTextPDF(rendered.Subject, rendered.Text, data.IssuedAt)
+	if err != nil {
+		return nil, err
+	}
+
+	return &renderedInvoice{Number: data.Number, HTML: []byte(rendered.HTML), PDF: pdf}, nil
+}
+
+// issueInvoice renders the invoice of transaction and stores it in its "invoice_files".
+func issueInvoice(app core.App, transaction, license *core.Record, locale string) (*renderedInvoice, error) {
+	invoice, err := renderInvoice(app, transaction, license, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := invoice.files()
+	if err != nil {
+		return nil, err
+	}
+	transaction.Set("invoice_files", files)
+	if err := app.Save(transaction); err != nil {
+		return nil, err
+	}
+	return invoice, nil
+}
+
+// storedInvoice reads the invoice issued for transaction, or returns nil if none was stored.
+func storedInvoice(app core.App, transaction *core.Record) (*renderedInvoice, error) {
+	names := transaction.GetStringSlice("invoice_files")
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	fsys, err := app.NewFilesystem()
+	if err != nil {
+		return nil, err
+	}
+	defer fsys.Close()
+
+	invoice := &renderedInvoice{Number: formatInvoiceNumber(transaction.GetInt("invoice_number"))}
+	for _, name := range names {
+		reader, err := fsys.GetReader(transaction.BaseFilesPath() + "/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("reading invoice %s: %w", name, err)
+		}
+		data, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading invoice %s: %w", name, err)
+		}
+
+		switch path.Ext(name) {
+		case ".html":
+			invoice.HTML = data
+		case ".pdf":
+			invoice.PDF = data
+		}
+	}
+	return invoice, nil
+}
+
+// files returns the invoice as email attachments.
+func (i *renderedInvoice) files() ([]*filesystem.File, error) {
+	html, err := filesystem.NewFileFromBytes(i.HTML, "invoice-"+i.Number+".html")
+	if err != nil {
+		return nil, err
+	}
+	pdf, err := filesystem.NewFileFromBytes(i.PDF, "invoice-"+i.Number+".pdf")
+	if err != nil {
+		return nil, err
+	}
+	return []*filesystem.File{html, pdf}, nil
+}
+
+// handleInvoice lets customers download the invoice of their license again,
+// authenticated by the email it was bought with and the license key.
+func handleInvoice(app core.App) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		payload := struct {
+			Email  string `json:"email"`
+			Key    string `json:"key"`
+			Format string `json:"format"` // "pdf" (default) or "html"
+		}{}
+		if err := e.BindBody(&payload); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+
+		license, err := findCustomerLicense(e.App, payload.Email, payload.Key)
+		if err != nil {
+			return apis.NewNotFoundError("License not found or invalid.", nil)
+		}
+
+		return serveInvoice(e, license, payload.Format)
+	}
+}
+
+// serveInvoice responds with the invoice of license as a download, format is
+// "pdf" (the default) or "html".
+func serveInvoice(e *core.RequestEvent, license *core.Record, format string) error {
+	if format == "" {
+		format = "pdf"
+	}
+	if format != "pdf" && format != "html" {
+		return apis.NewBadRequestError("Format must be pdf or html.", nil)
+	}
+
+	var invoice *renderedInvoice
+	err := e.App.RunInTransaction(func(txApp core.App) error {
+		transaction, err := txApp.FindRecordById("transactions", license.GetString("transaction"))
+		if err != nil || transaction.GetInt("invoice_number") == 0 {
+			return errNoInvoice
+		}
+
+		invoice, err = storedInvoice(txApp, transaction)
+		if err != nil || invoice != nil {
+			return err
+		}
+
+		// Not rendered when the purchase was fulfilled, issue it now.
+		invoice, err = issueInvoice(txApp, transaction, license, userLocale(txApp, transaction.GetString("user_email")))
+		return err
+	})
+	if errors.Is(err, errNoInvoice) {
+		return apis.NewNotFoundError("There is no invoice for this license.", nil)
+	}
+	if err != nil {
+		return apis.NewApiError(http.StatusInternalServerError, "Could not load the invoice.", err)
+	}
+
+	filename := "invoice-" + invoice.Number + "." + format
+	e.Response.Header().Set("Content-Disposition", `attachment; filename="`+filename+`"`)
+	if format == "html" {
+		return e.Blob(http.StatusOK, "text/html; charset=utf-8", invoice.HTML)
+	}
+	return e.Blob(http.StatusOK, "application/pdf", invoice.PDF)
+}
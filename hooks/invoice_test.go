@@ -0,0 +1,135 @@
+package hooks
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+)
+
+func TestInvoiceNumbersHaveNoGaps(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	// Purchases arriving at the same time, one of them failing in between.
+	restore := failTestFulfilments(app)
+	sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_failed", "failed@example.com"))
+	restore()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			id := fmt.Sprintf("pi_concurrent_%d", i)
+			sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout(id, id+"@example.com"))
+		}()
+	}
+	wg.Wait()
+
+	transactions, err := app.FindAllRecords("transactions", dbx.HashExp{"status": "completed"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var numbers []int
+	for _, transaction := range transactions {
+		numbers = append(numbers, transaction.GetInt("invoice_number"))
+	}
+	sort.Ints(numbers)
+	if len(numbers) != 10 {
+		t.Fatalf("expected 10 completed purchases, got %d", len(numbers))
+	}
+	for i, number := range numbers {
+		if number != i+1 {
+			t.Fatalf("expected the invoice numbers 1 to 10, got %v", numbers)
+		}
+	}
+
+	if number := findTestTransaction(t, app, "pi_failed").GetInt("invoice_number"); number != 0 {
+		t.Fatalf("expected the failed purchase to have no invoice number, got %d", number)
+	}
+}
+
+func TestInvoiceNumberIndex(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	// Failed purchases all keep the number 0.
+	restore := failTestFulfilments(app)
+	for i := 0; i < 3; i++ {
+		id := fmt.Sprintf("pi_failed_%d", i)
+		sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout(id, id+"@example.com"))
+	}
+	restore()
+	if n := countTestRecords(t, app, "transactions", dbx.HashExp{"status": "failed", "invoice_number": 0}); n != 3 {
+		t.Fatalf("expected 3 failed transactions without an invoice number, got %d", n)
+	}
+
+	// Issued numbers are unique.
+	decodeTestResponse(t, sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_paid", "paid@example.com")), http.StatusOK, nil)
+	duplicate := findTestTransaction(t, app, "pi_failed_0")
+	duplicate.Set("invoice_number", findTestTransaction(t, app, "pi_paid").GetInt("invoice_number"))
+	if err := app.Save(duplicate); err == nil {
+		t.Fatal("expected a duplicate invoice number to be refused")
+	}
+}
+
+func TestInvoiceIssuedOnDownload(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	decodeTestResponse(t, sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_lazy", "lazy@example.com")), http.StatusOK, nil)
+
+	// As if the invoice template failed when the purchase was fulfilled.
+	transaction := findTestTransaction(t, app, "pi_lazy")
+	transaction.Set("invoice_files", []string{})
+	if err := app.Save(transaction); err != nil {
+		t.Fatal(err)
+	}
+
+	license, err := app.FindFirstRecordByData("licenses", "purchase_id", "pi_lazy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := revealLicenseKey(license)
+	if err != nil {
+		t.Fatal(err)
+	}
+	download := func(format string) []byte {
+		t.Helper()
+		rec := sendTestRequest(router, http.MethodPost, "/api/v1/invoice", map[string]any{
+			"email":  "lazy@example.com",
+			"key":    key,
+			"format": format,
+		}, nil)
+		if rec.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", rec.Code, rec.Body.String())
+		}
+		return rec.Body.Bytes()
+	}
+
+	html, pdf := download("html"), download("pdf")
+	if files := findTestTransaction(t, app, "pi_lazy").GetStringSlice("invoice_files"); len(files) != 2 {
+		t.Fatalf("expected the invoice to be stored on the first download, got %v", files)
+	}
+
+	// Later template edits don't change the issued invoice.
+	saveTestRecord(t, app, "email_templates", map[string]any{
+		"name":   "invoice",
+		"locale": defaultLocale,
+		"html":   "<p>Another seller, invoice {{.Number}}</p>",
+		"text":   "Another seller, invoice {{.Number}}",
+	})
+	if again := download("html"); string(again) != string(html) {
+		t.Fatalf("expected the issued html invoice, got %s", again)
+	}
+	if again := download("pdf"); string(again) != string(pdf) {
+		t.Fatal("expected the issued pdf invoice")
+	}
+}
@@ -0,0 +1,188 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"os"
+	"strings"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// License keys are never stored in plaintext. Each license keeps:
+//   - key_lookup: a short keyed digest of the key, indexed, used to narrow down candidates
+//   - key_hash:   a salted keyed hash (with key_salt) that confirms a candidate
+//   - key_hint:   a masked form of the key for the admin UI
+//   - key_sealed: the key encrypted with a key derived from LICENSE_KEY_SECRET,
+//     so it can still be emailed to the customer
+//
+// Without LICENSE_KEY_SECRET a copy of the database is not enough to recover any key,
+// apart from the ones in the emails kept in the outbox for resends.
+//
+// Admins set or replace a key through the hidden "key" field, which is only
+// ever empty in the database, see registerLicenseKeyHooks.
+
+// licenseKeyLookupLength is kept short on purpose; the lookup only narrows down
+// the candidates and key_hash is what actually confirms a key.
+const licenseKeyLookupLength = 8
+
+var errNoKeySecret = errors.New("LICENSE_KEY_SECRET must be set to at least 32 characters")
+
+// licenseKeySecret reads the server side secret used to hash and seal license keys.
+func licenseKeySecret() (string, error) {
+	secret := os.Getenv("LICENSE_KEY_SECRET")
+	if len(secret) < 32 {
+		return "", errNoKeySecret
+	}
+	return secret, nil
+}
+
+// normalizeLicenseKey makes lookups tolerant to case and surrounding whitespace.
+func normalizeLicenseKey(key string) string {
+	return strings.ToUpper(strings.TrimSpace(key))
+}
+
+func keyedDigest(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func licenseKeyLookup(secret, key string) string {
+	return keyedDigest(secret, "lookup:"+key)[:licenseKeyLookupLength]
+}
+
+func licenseKeyHash(secret, salt, key string) string {
+	return keyedDigest(secret, "hash:"+salt+":"+key)
+}
+
+// licenseKeySealKey derives the 32 byte AES key used for key_sealed.
+func licenseKeySealKey(secret string) string {
+	sum := sha256.Sum256([]byte("seal:" + secret))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+// maskLicenseKey hides everything but the prefix and the last two characters,
+// e.g. "C1P-***-*K7".
+func maskLicenseKey(key string) string {
+	masked := []byte(key)
+	for i := len(licensePrefix); i < len(masked)-2; i++ {
+		if masked[i] != '-' {
+			masked[i] = '*'
+		}
+	}
+	return string(masked)
+}
+
+// setLicenseKey stores the hashed, masked and sealed forms of key on the license.
+func setLicenseKey(license *core.Record, key string) error {
+	secret, err := licenseKeySecret()
+	if err != nil {
+		return err
+	}
+
+	salt, err := GenerateSalt(32)
+	if err != nil {
+		return err
+	}
+
+	key = normalizeLicenseKey(key)
+	sealed, err := security.Encrypt([]byte(key), licenseKeySealKey(secret))
+	if err != nil {
+		return err
+	}
+
+	license.Set("key_salt", salt)
+	license.Set("key_lookup", licenseKeyLookup(secret, key))
+	license.Set("key_hash", licenseKeyHash(secret, salt, key))
+	license.Set("key_hint", maskLicenseKey(key))
+	license.Set("key_sealed", sealed)
+	return nil
+}
+
+// findLicenseByKey returns the license the key belongs to, or sql.ErrNoRows.
+func findLicenseByKey(app core.App, key string) (*core.Record, error) {
+	secret, err := licenseKeySecret()
+	if err != nil {
+		return nil, err
+	}
+
+	key = normalizeLicenseKey(key)
+	candidates, err := app.FindAllRecords("licenses", dbx.HashExp{"key_lookup": licenseKeyLookup(secret, key)})
+	if err != nil {
+		return nil, err
+	}
+
+	for _, license := range candidates {
+		expected := licenseKeyHash(secret, license.GetString("key_salt"), key)
+		if hmac.Equal([]byte(expected), []byte(license.GetString("key_hash"))) {
+			return license, nil
+		}
+	}
+
+	return nil, sql.ErrNoRows
+}
+
+// revealLicenseKey decrypts the key of a license, e.g. to email it to the customer again.
+func revealLicenseKey(license *core.Record) (string, error) {
+	secret, err := licenseKeySecret()
+	if err != nil {
+		return "", err
+	}
+
+	key, err := security.Decrypt(license.GetString("key_sealed"), licenseKeySealKey(secret))
+	if err != nil {
+		return "", err
+	}
+	return string(key), nil
+}
+
+// registerLicenseKeyHooks hashes and seals the key an admin types into the "key"
+// field and clears it before the license is saved. A license created without a
+// key, e.g. from the admin UI, gets a generated one.
+func registerLicenseKeyHooks(app core.App) {
+	storeKey := func(e *core.RecordEvent) error {
+		key := normalizeLicenseKey(e.Record.GetString("key"))
+		e.Record.Set("key", "")
+
+		switch {
+		case key != "":
+			if n := len(key); n < 9 || n > 12 {
+				return validation.Errors{
+					"key": validation.NewError("validation_invalid_key", "The key must be 9 to 12 characters long."),
+				}
+			}
+			existing, err := findLicenseByKey(e.App, key)
+			if err == nil && existing.Id != e.Record.Id {
+				return validation.Errors{
+					"key": validation.NewError("validation_key_taken", "Another license already uses this key."),
+				}
+			}
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		case e.Record.IsNew() && e.Record.GetString("key_hash") == "":
+			generated, err := GenerateUniqueKey(e.App)
+			if err != nil {
+				return err
+			}
+			key = generated
+		default:
+			return e.Next()
+		}
+
+		if err := setLicenseKey(e.Record, key); err != nil {
+			return err
+		}
+		return e.Next()
+	}
+
+	app.OnRecordCreate("licenses").BindFunc(storeKey)
+	app.OnRecordUpdate("licenses").BindFunc(storeKey)
+}
@@ -0,0 +1,225 @@
+package hooks
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+var testKeyPattern = regexp.MustCompile(`^C1P-[` + licenseChars + `]{3}-[` + licenseChars + `]{3}$`)
+
+func TestSetLicenseKey(t *testing.T) {
+	app := newHubTestApp(t)
+	license, key := newTestLicense(t, app, "keys@example.com", nil)
+
+	if !testKeyPattern.MatchString(key) {
+		t.Fatalf("unexpected key format %q", key)
+	}
+	for _, field := range []string{"key", "key_lookup", "key_hash", "key_hint", "key_sealed", "key_salt"} {
+		if strings.Contains(license.GetString(field), key) {
+			t.Fatalf("the plaintext key is stored in %s", field)
+		}
+	}
+	if hint := license.GetString("key_hint"); hint != "C1P-***-*"+key[len(key)-2:] {
+		t.Fatalf("unexpected hint %q for %q", hint, key)
+	}
+
+	revealed, err := revealLicenseKey(license)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revealed != key {
+		t.Fatalf("expected the key %q to be revealed, got %q", key, revealed)
+	}
+
+	// The same key set on another license is salted differently.
+	other, _ := newTestLicense(t, app, "other@example.com", nil)
+	if err := setLicenseKey(other, key); err != nil {
+		t.Fatal(err)
+	}
+	if other.GetString("key_hash") == license.GetString("key_hash") {
+		t.Fatal("expected the key hashes to be salted per license")
+	}
+	if other.GetString("key_lookup") != license.GetString("key_lookup") {
+		t.Fatal("expected the key lookups to match")
+	}
+}
+
+func TestFindLicenseByKey(t *testing.T) {
+	app := newHubTestApp(t)
+	license, key := newTestLicense(t, app, "find@example.com", nil)
+	newTestLicense(t, app, "decoy@example.com", nil)
+
+	for _, lookup := range []string{key, strings.ToLower(key), "  " + key + "\n"} {
+		found, err := findLicenseByKey(app, lookup)
+		if err != nil {
+			t.Fatalf("%q: %v", lookup, err)
+		}
+		if found.Id != license.Id {
+			t.Fatalf("%q: expected license %s, got %s", lookup, license.Id, found.Id)
+		}
+	}
+
+	wrong := key[:len(key)-1] + "Z"
+	if wrong == key {
+		wrong = key[:len(key)-1] + "Y"
+	}
+	for _, lookup := range []string{wrong, "", "C1P"} {
+		if _, err := findLicenseByKey(app, lookup); !errors.Is(err, sql.ErrNoRows) {
+			t.Fatalf("%q: expected sql.ErrNoRows, got %v", lookup, err)
+		}
+	}
+}
+
+func TestLicenseKeysRequireSecret(t *testing.T) {
+	app := newHubTestApp(t)
+	license, key := newTestLicense(t, app, "secret@example.com", nil)
+
+	t.Setenv("LICENSE_KEY_SECRET", "another-license-key-secret-0123456789")
+	if _, err := findLicenseByKey(app, key); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the key not to be found with another secret, got %v", err)
+	}
+	if _, err := revealLicenseKey(license); err == nil {
+		t.Fatal("expected the key not to be revealed with another secret")
+	}
+
+	t.Setenv("LICENSE_KEY_SECRET", "short")
+	if err := setLicenseKey(license, key); !errors.Is(err, errNoKeySecret) {
+		t.Fatalf("expected errNoKeySecret, got %v", err)
+	}
+	if _, err := findLicenseByKey(app, key); !errors.Is(err, errNoKeySecret) {
+		t.Fatalf("expected errNoKeySecret, got %v", err)
+	}
+}
+
+func TestAdminSetsLicenseKey(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+	superuser := http.Header{"Authorization": {newTestSuperuserToken(t, app)}}
+	user := newTestUser(t, app, "admin-keys@example.com")
+
+	// Without a key, one is generated.
+	var generated struct {
+		ID  string `json:"id"`
+		Key string `json:"key"`
+	}
+	rec := sendTestRequest(router, http.MethodPost, "/api/collections/licenses/records", map[string]any{
+		"user":             user.Id,
+		"purchase_id":      "manual-1",
+		"status":           "active",
+		"tier":             "pro",
+		"activation_limit": defaultActivationLimit,
+	}, superuser)
+	decodeTestResponse(t, rec, http.StatusOK, &generated)
+	if generated.Key != "" {
+		t.Fatalf("expected the plaintext key not to be returned, got %q", generated.Key)
+	}
+	license, err := app.FindRecordById("licenses", generated.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := revealLicenseKey(license)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !testKeyPattern.MatchString(key) {
+		t.Fatalf("unexpected generated key %q", key)
+	}
+
+	// A typed key is normalized and replaces the previous one.
+	rec = sendTestRequest(router, http.MethodPatch, "/api/collections/licenses/records/"+license.Id, map[string]any{
+		"key": " c1p-abc-def ",
+	}, superuser)
+	decodeTestResponse(t, rec, http.StatusOK, nil)
+	if _, err := findLicenseByKey(app, key); !errors.Is(err, sql.ErrNoRows) {
+		t.Fatalf("expected the previous key to stop working, got %v", err)
+	}
+	found, err := findLicenseByKey(app, "C1P-ABC-DEF")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Id != license.Id {
+		t.Fatalf("expected license %s, got %s", license.Id, found.Id)
+	}
+	if stored := found.GetString("key"); stored != "" {
+		t.Fatalf("expected the plaintext key not to be stored, got %q", stored)
+	}
+
+	// An update without a key keeps it.
+	rec = sendTestRequest(router, http.MethodPatch, "/api/collections/licenses/records/"+license.Id, map[string]any{
+		"status": "on_hold",
+	}, superuser)
+	decodeTestResponse(t, rec, http.StatusOK, nil)
+	if _, err := findLicenseByKey(app, "C1P-ABC-DEF"); err != nil {
+		t.Fatalf("expected the key to be kept, got %v", err)
+	}
+
+	// Keys stay unique.
+	rec = sendTestRequest(router, http.MethodPost, "/api/collections/licenses/records", map[string]any{
+		"user":             user.Id,
+		"purchase_id":      "manual-2",
+		"status":           "active",
+		"tier":             "pro",
+		"key":              "C1P-ABC-DEF",
+		"activation_limit": defaultActivationLimit,
+	}, superuser)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+	if !strings.Contains(rec.Body.String(), "validation_key_taken") {
+		t.Fatalf("expected the key to be rejected as taken, got %s", rec.Body.String())
+	}
+
+	rec = sendTestRequest(router, http.MethodPatch, "/api/collections/licenses/records/"+license.Id, map[string]any{
+		"key": "C1P",
+	}, superuser)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+}
+
+func TestMigrationHashesExistingKeys(t *testing.T) {
+	t.Setenv("LICENSE_KEY_SECRET", testLicenseKeySecret)
+
+	var before core.MigrationsList
+	for _, migration := range appMigrations.Items() {
+		if migration.File < "1792200500" {
+			before.Add(migration)
+		}
+	}
+	app := newMigratedTestApp(t, before)
+
+	user := newTestUser(t, app, "legacy@example.com")
+	legacy := saveTestRecord(t, app, "licenses", map[string]any{
+		"user":             user.Id,
+		"key":              "c1p-leg-acy",
+		"key_salt":         security.RandomString(32),
+		"purchase_id":      "legacy-1",
+		"status":           "active",
+		"tier":             "pro",
+		"activation_limit": defaultActivationLimit,
+	})
+
+	if _, err := core.NewMigrationsRunner(app, appMigrations).Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := findLicenseByKey(app, "C1P-LEG-ACY")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if found.Id != legacy.Id {
+		t.Fatalf("expected license %s, got %s", legacy.Id, found.Id)
+	}
+	if revealed, err := revealLicenseKey(found); err != nil || revealed != "C1P-LEG-ACY" {
+		t.Fatalf("expected the key to be revealed, got %q (%v)", revealed, err)
+	}
+	if hint := found.GetString("key_hint"); hint != "C1P-***-*CY" {
+		t.Fatalf("unexpected hint %q", hint)
+	}
+	if stored := found.GetString("key"); stored != "" {
+		t.Fatalf("expected the plaintext key to be dropped, got %q", stored)
+	}
+}
@@ -2,10 +2,17 @@ package hooks
 
 import (
 	"crypto/rand"
+	"database/sql"
+	"errors"
 	"fmt"
 	"math/big"
+	"os"
+	"strconv"
+	"time"
 
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/types"
 )
 
 const (
@@ -41,42 +48,109 @@ func GenerateUniqueKey(app core.App) (string, error) {
 		key := fmt.Sprintf("%s-%s-%s", licensePrefix, seg1, seg2)
 
 		// Check for uniqueness. A "no rows in result set" error is the success case.
-		if _, err := app.FindFirstRecordByData("licenses", "key", key); err != nil {
+		_, err = findLicenseByKey(app, key)
+		if err == sql.ErrNoRows {
 			return key, nil // The key is unique
 		}
-		
+		if err != nil {
+			return "", err
+		}
+
 		// Key already exists, we loop again
 	}
 	return "", fmt.Errorf("failed to generate a unique license key after 10 attempts")
 }
 
+// deviceInfo describes the machine a license is activated on, as reported by the app.
+type deviceInfo struct {
+	ID        string
+	Name      string
+	OSVersion string
+	AppBuild  int
+}
+
+// findLicenseDevice returns the devices record of deviceID on the license.
+func findLicenseDevice(app core.App, license *core.Record, deviceID string) (*core.Record, error) {
+	return app.FindFirstRecordByFilter(
+		"devices",
+		"license = {:license} && device_id = {:device}",
+		dbx.Params{"license": license.Id, "device": deviceID},
+	)
+}
+
+// touchDevice marks the device as seen now and refreshes what it reports about itself.
+func touchDevice(device *core.Record, info deviceInfo, now time.Time) {
+	if info.Name != "" {
+		device.Set("name", info.Name)
+	}
+	if info.OSVersion != "" {
+		device.Set("os_version", info.OSVersion)
+	}
+	if info.AppBuild > 0 {
+		device.Set("app_build", info.AppBuild)
+	}
+	device.Set("last_seen_at", now)
+}
+
 // activateDeviceIfNeeded checks the device limit and adds the new device if a slot is available.
 // It returns a boolean indicating if the activation was successful, and an error if one occurred.
-func activateDeviceIfNeeded(app core.App, license *core.Record, deviceID string) (bool, error) {
-	activatedDevices := license.GetStringSlice("activated_devices")
+//
+// The check and the insert run in one db transaction (transactions are serialized by
+// the app's single writer connection), so concurrent activations can't exceed the limit.
+func activateDeviceIfNeeded(app core.App, license *core.Record, info deviceInfo) (bool, error) {
+	now := time.Now().UTC()
+	activated := false
 
-	// Check if device is already activated
-	for _, id := range activatedDevices {
-		if id == deviceID {
-			return true, nil // Already active, success.
+	err := app.RunInTransaction(func(txApp core.App) error {
+		// Re-read the license, the limit may have changed since it was loaded.
+		current, err := txApp.FindRecordById("licenses", license.Id)
+		if err != nil {
+			return err
 		}
-	}
 
-	// Device is new, check if there is a free slot
-	activationLimit := license.GetInt("activation_limit")
-	if len(activatedDevices) >= activationLimit {
-		return false, nil // Limit reached, not an error but a business rule failure.
-	}
+		// Check if device is already activated
+		device, err := findLicenseDevice(txApp, current, info.ID)
+		if err == nil {
+			touchDevice(device, info, now)
+			activated = true // Already active, success.
+			return txApp.Save(device)
+		}
+		if err != sql.ErrNoRows {
+			return err
+		}
 
-	// Add the new device and save
-	activatedDevices = append(activatedDevices, deviceID)
-	license.Set("activated_devices", activatedDevices)
+		// Device is new, check if there is a free slot
+		activatedCount, err := txApp.CountRecords("devices", dbx.HashExp{"license": current.Id})
+		if err != nil {
+			return err
+		}
+		if int(activatedCount) >= current.GetInt("activation_limit") {
+			return nil // Limit reached, not an error but a business rule failure.
+		}
 
-	if err := app.Save(license); err != nil {
-		return false, err // Database error on save
+		// Add the new device and save
+		collection, err := txApp.FindCollectionByNameOrId("devices")
+		if err != nil {
+			return err
+		}
+		device = core.NewRecord(collection)
+		device.Set("license", current.Id)
+		device.Set("device_id", info.ID)
+		device.Set("first_activated_at", now)
+		touchDevice(device, info, now)
+
+		if err := txApp.Save(device); err != nil {
+			return err // Database error on save
+		}
+
+		activated = true // Activation successful
+		return nil
+	})
+	if err != nil {
+		return false, err
 	}
 
-	return true, nil // Activation successful
+	return activated, nil
 }
 
 func GenerateSalt(length int) (string, error) {
@@ -90,4 +164,114 @@ func GenerateSalt(length int) (string, error) {
         salt[i] = saltChars[num.Int64()]
     }
     return string(salt), nil
-}
\ No newline at end of file
+}
+
+// Deactivations are limited per license so seats can't be cycled between machines.
+// Both limits are disabled when set to 0.
+const (
+	defaultDeactivationCooldown     = 0
+	defaultDeactivationMonthlyLimit = 3
+)
+
+var (
+	errDeactivationCooldown = errors.New("deactivation cooldown has not elapsed")
+	errDeactivationLimit    = errors.New("monthly deactivation limit reached")
+)
+
+// deactivationLimits reads DEACTIVATION_COOLDOWN (a Go duration, e.g. "24h")
+// and DEACTIVATION_MONTHLY_LIMIT (deactivations per rolling 30 days).
+func deactivationLimits() (time.Duration, int) {
+	cooldown := time.Duration(defaultDeactivationCooldown)
+	if d, err := time.ParseDuration(os.Getenv("DEACTIVATION_COOLDOWN")); err == nil && d >= 0 {
+		cooldown = d
+	}
+
+	monthlyLimit := defaultDeactivationMonthlyLimit
+	if n, err := strconv.Atoi(os.Getenv("DEACTIVATION_MONTHLY_LIMIT")); err == nil && n >= 0 {
+		monthlyLimit = n
+	}
+
+	return cooldown, monthlyLimit
+}
+
+// checkDeactivationAllowed enforces the per-license deactivation cooldown and monthly cap.
+func checkDeactivationAllowed(app core.App, license *core.Record, now time.Time) error {
+	cooldown, monthlyLimit := deactivationLimits()
+
+	if cooldown > 0 {
+		recent, err := app.CountRecords("deactivations", dbx.HashExp{"license": license.Id}, dbx.NewExp(
+			"created > {:since}", dbx.Params{"since": now.Add(-cooldown).UTC().Format(types.DefaultDateLayout)},
+		))
+		if err != nil {
+			return err
+		}
+		if recent > 0 {
+			return errDeactivationCooldown
+		}
+	}
+
+	if monthlyLimit > 0 {
+		recent, err := app.CountRecords("deactivations", dbx.HashExp{"license": license.Id}, dbx.NewExp(
+			"created > {:since}", dbx.Params{"since": now.AddDate(0, 0, -30).UTC().Format(types.DefaultDateLayout)},
+		))
+		if err != nil {
+			return err
+		}
+		if int(recent) >= monthlyLimit {
+			return errDeactivationLimit
+		}
+	}
+
+	return nil
+}
+
+// deactivateDevice frees the license seat used by deviceID and logs the deactivation.
+// It returns false if the device wasn't activated on the license, and
+// errDeactivationCooldown or errDeactivationLimit if the limits don't allow it.
+//
+// The limits are checked in the same db transaction as the delete, like the
+// activation limit in activateDeviceIfNeeded, so concurrent deactivations can't exceed them.
+func deactivateDevice(app core.App, license *core.Record, deviceID, ip string, now time.Time) (bool, error) {
+	deactivated := false
+
+	err := app.RunInTransaction(func(txApp core.App) error {
+		device, err := findLicenseDevice(txApp, license, deviceID)
+		if err == sql.ErrNoRows {
+			return nil // Not activated, nothing to free.
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := checkDeactivationAllowed(txApp, license, now); err != nil {
+			return err
+		}
+
+		if err := txApp.Delete(device); err != nil {
+			return err
+		}
+
+		collection, err := txApp.FindCollectionByNameOrId("deactivations")
+		if err != nil {
+			return err
+		}
+		deactivation := core.NewRecord(collection)
+		deactivation.Set("license", license.Id)
+		deactivation.Set("device_id", deviceID)
+		deactivation.Set("ip", ip)
+		if err := txApp.Save(deactivation); err != nil {
+			return err
+		}
+
+		deactivated = true
+		return nil
+	})
+	if err != nil {
+		return false, err
+	}
+
+	if deactivated {
+		app.Logger().Info("Device deactivated", "license", license.Id, "deviceId", deviceID, "ip", ip)
+	}
+	return deactivated, nil
+}
@@ -0,0 +1,259 @@
+package hooks
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+// newLicenseTestApp creates a test app with the minimal licenses, devices and
+// deactivations collections that the activation code relies on.
+func newLicenseTestApp(t *testing.T) *tests.TestApp {
+	t.Helper()
+
+	app, err := tests.NewTestApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	licenses := core.NewBaseCollection("licenses")
+	licenses.Fields.Add(
+		&core.TextField{Name: "status"},
+		&core.NumberField{Name: "activation_limit", OnlyInt: true},
+	)
+	if err := app.Save(licenses); err != nil {
+		t.Fatal(err)
+	}
+
+	devices := core.NewBaseCollection("devices")
+	devices.Fields.Add(
+		&core.RelationField{Name: "license", CollectionId: licenses.Id, MaxSelect: 1, Required: true},
+		&core.TextField{Name: "device_id", Required: true},
+		&core.TextField{Name: "name"},
+		&core.TextField{Name: "os_version"},
+		&core.NumberField{Name: "app_build", OnlyInt: true},
+		&core.DateField{Name: "first_activated_at"},
+		&core.DateField{Name: "last_seen_at"},
+	)
+	devices.AddIndex("idx_devices_license_device", true, "license, device_id", "")
+	if err := app.Save(devices); err != nil {
+		t.Fatal(err)
+	}
+
+	deactivations := core.NewBaseCollection("deactivations")
+	deactivations.Fields.Add(
+		&core.RelationField{Name: "license", CollectionId: licenses.Id, MaxSelect: 1, Required: true},
+		&core.TextField{Name: "device_id"},
+		&core.TextField{Name: "ip"},
+		&core.AutodateField{Name: "created", OnCreate: true},
+	)
+	if err := app.Save(deactivations); err != nil {
+		t.Fatal(err)
+	}
+
+	return app
+}
+
+func TestActivateDeviceIfNeededConcurrentLimit(t *testing.T) {
+	app := newLicenseTestApp(t)
+
+	const activationLimit = 3
+	const attempts = 20
+
+	collection, err := app.FindCollectionByNameOrId("licenses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	license := core.NewRecord(collection)
+	license.Set("status", "active")
+	license.Set("activation_limit", activationLimit)
+	if err := app.Save(license); err != nil {
+		t.Fatal(err)
+	}
+
+	// Widen the window between the limit check and the insert so that
+	// an unsynchronized check-then-save reliably overshoots the limit.
+	app.OnRecordCreate("devices").BindFunc(func(e *core.RecordEvent) error {
+		time.Sleep(5 * time.Millisecond)
+		return e.Next()
+	})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	activated := 0
+
+	for i := 0; i < attempts; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ok, err := activateDeviceIfNeeded(app, license, deviceInfo{ID: fmt.Sprintf("device-%d", i)})
+			if err != nil {
+				t.Errorf("activation %d failed: %v", i, err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				activated++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if activated != activationLimit {
+		t.Fatalf("Expected %d successful activations, got %d", activationLimit, activated)
+	}
+
+	total, err := app.CountRecords("devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != activationLimit {
+		t.Fatalf("Expected %d device records, got %d", activationLimit, total)
+	}
+}
+
+func TestActivateDeviceIfNeededConcurrentSameDevice(t *testing.T) {
+	app := newLicenseTestApp(t)
+
+	collection, err := app.FindCollectionByNameOrId("licenses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	license := core.NewRecord(collection)
+	license.Set("status", "active")
+	license.Set("activation_limit", 2)
+	if err := app.Save(license); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ok, err := activateDeviceIfNeeded(app, license, deviceInfo{ID: "same-device"})
+			if err != nil {
+				t.Errorf("activation failed: %v", err)
+				return
+			}
+			if !ok {
+				t.Error("Expected re-activating the same device to succeed")
+			}
+		}()
+	}
+	wg.Wait()
+
+	total, err := app.CountRecords("devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if total != 1 {
+		t.Fatalf("Expected a single device record, got %d", total)
+	}
+}
+
+func TestDeactivateDeviceConcurrentLimit(t *testing.T) {
+	t.Setenv("DEACTIVATION_COOLDOWN", "0")
+	t.Setenv("DEACTIVATION_MONTHLY_LIMIT", "2")
+
+	app := newLicenseTestApp(t)
+
+	const devices = 10
+
+	collection, err := app.FindCollectionByNameOrId("licenses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	license := core.NewRecord(collection)
+	license.Set("status", "active")
+	license.Set("activation_limit", devices)
+	if err := app.Save(license); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < devices; i++ {
+		if ok, err := activateDeviceIfNeeded(app, license, deviceInfo{ID: fmt.Sprintf("device-%d", i)}); err != nil || !ok {
+			t.Fatalf("activation %d failed: %v", i, err)
+		}
+	}
+
+	// Widen the window between the limit check and the logged deactivation.
+	app.OnRecordCreate("deactivations").BindFunc(func(e *core.RecordEvent) error {
+		time.Sleep(5 * time.Millisecond)
+		return e.Next()
+	})
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	deactivated := 0
+
+	for i := 0; i < devices; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+
+			ok, err := deactivateDevice(app, license, fmt.Sprintf("device-%d", i), "127.0.0.1", time.Now())
+			if errors.Is(err, errDeactivationLimit) {
+				return
+			}
+			if err != nil {
+				t.Errorf("deactivation %d failed: %v", i, err)
+				return
+			}
+			if ok {
+				mu.Lock()
+				deactivated++
+				mu.Unlock()
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if deactivated != 2 {
+		t.Fatalf("Expected 2 successful deactivations, got %d", deactivated)
+	}
+
+	remaining, err := app.CountRecords("devices")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if remaining != devices-2 {
+		t.Fatalf("Expected %d devices left, got %d", devices-2, remaining)
+	}
+}
+
+func TestDeactivateDeviceNotActivated(t *testing.T) {
+	app := newLicenseTestApp(t)
+
+	collection, err := app.FindCollectionByNameOrId("licenses")
+	if err != nil {
+		t.Fatal(err)
+	}
+	license := core.NewRecord(collection)
+	license.Set("status", "active")
+	license.Set("activation_limit", 1)
+	if err := app.Save(license); err != nil {
+		t.Fatal(err)
+	}
+
+	ok, err := deactivateDevice(app, license, "unknown-device", "127.0.0.1", time.Now())
+	if err != nil || ok {
+		t.Fatalf("Expected an unknown device to be reported as not activated, got %v %v", ok, err)
+	}
+
+	logged, err := app.CountRecords("deactivations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if logged != 0 {
+		t.Fatalf("Expected no logged deactivation, got %d", logged)
+	}
+}
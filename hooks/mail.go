@@ -1,45 +1,358 @@
 package hooks
 
 import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"errors"
 	"fmt"
-	"log"
+	htmltemplate "html/template"
+	"io"
+	"io/fs"
 	"net/mail"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	texttemplate "text/template"
+	"time"
 
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/core"
-	"github.com/pocketbase/pocketbase/tools/mailer"
 )
 
-// SendLicenseEmail sends the welcome/purchase email with the new license key.
-// It can also be used for the "Lost License" flow.
-func SendLicenseEmail(app core.App, toEmail, toName, key string) {
-	htmlBody := fmt.Sprintf(`
-		<html>
-			<body>
-				<h2>Welcome to CursorClip Recorder!</h2>
-				<p>Hello %s,</p>
-				<p>Thank you for your interest in CursorClip Recorder!</p>
-				<p>Your License Key is:</p>
-				<pre style="background-color: #f5f5f5; padding: 10px; border-radius: 5px;">%s</pre>
-				<p>Best regards,<br>The CursorClip Team</p>
-			</body>
-		</html>
-	`, toName, key)
-
-	message := &mailer.Message{
-		From: mail.Address{
-			Address: app.Settings().Meta.SenderAddress,
-			Name:    app.Settings().Meta.SenderName,
+// Every email is rendered from three templates per locale: the subject and the
+// plain-text part with text/template, the HTML part with html/template so the
+// customer data is escaped. Each part is taken from the first of
+//
+//	the "email_templates" collection, editable in the admin UI
+//	pb_data/emails/<name>.<locale>.subject.txt, .html and .txt
+//	the defaults embedded from the emails directory
+//
+// for the user's language, then again for defaultLocale.
+
+//go:embed emails
+var defaultEmailTemplates embed.FS
+
+// defaultLocale is used for the users without a language and the templates not translated yet.
+const defaultLocale = "en"
+
+// supportedLocales are the values of the users "language" and email_templates "locale" fields.
+var supportedLocales = []string{"en", "de"}
+
+// emailTemplateParts maps the email_templates fields to the template file suffixes.
+var emailTemplateParts = []struct{ field, suffix string }{
+	{"subject", ".subject.txt"},
+	{"html", ".html"},
+	{"text", ".txt"},
+}
+
+// renderedEmail is an email ready to be sent.
+type renderedEmail struct {
+	Subject string
+	HTML    string
+	Text    string
+}
+
+// licenseKeyEmail is the data of the "license_key" template.
+type licenseKeyEmail struct {
+	Name          string
+	Key           string
+	InvoiceNumber string // empty when no invoice is attached
+}
+
+// lostLicenseEmail is the data of the "lost_license" template.
+type lostLicenseEmail struct {
+	Name      string
+	Licenses  []lostLicenseEmailLicense
+	ManageURL string // empty when MANAGE_DEVICES_URL isn't set
+}
+
+// portalLinkEmail is the data of the "portal_link" template.
+type portalLinkEmail struct {
+	Name      string
+	Link      string
+	ExpiresIn int // minutes
+}
+
+type lostLicenseEmailLicense struct {
+	Key         string // empty for revoked licenses
+	Status      string
+	Revoked     bool
+	Tier        string
+	Devices     int
+	DeviceLimit int
+	PurchasedAt time.Time
+}
+
+// emailTemplateSamples is the data each template is tried with before an override
+// is saved, one sample per branch the default templates take.
+var emailTemplateSamples = map[string][]any{
+	"license_key": {
+		licenseKeyEmail{Name: "Jane Doe", Key: "C1P-TAD-QHN", InvoiceNumber: "CC-000042"},
+		licenseKeyEmail{Key: "C1P-TAD-QHN"},
+	},
+	"lost_license": {
+		lostLicenseEmail{
+			Name: "Jane Doe",
+			Licenses: []lostLicenseEmailLicense{
+				{Key: "C1P-TAD-QHN", Status: "active", Tier: "pro", Devices: 1, DeviceLimit: 3, PurchasedAt: time.Now()},
+				{Key: "C1P-7KX-M4R", Status: "on_hold", Tier: "pro", Devices: 0, DeviceLimit: 3, PurchasedAt: time.Now()},
+				{Status: "revoked", Revoked: true, Tier: "basic", Devices: 2, DeviceLimit: 2, PurchasedAt: time.Now()},
+			},
+			ManageURL: "https://example.com/portal",
+		},
+		lostLicenseEmail{
+			Licenses: []lostLicenseEmailLicense{
+				{Key: "C1P-TAD-QHN", Status: "active", Tier: "pro", Devices: 1, DeviceLimit: 3, PurchasedAt: time.Now()},
+			},
 		},
-		To: []mail.Address{{
-			Address: toEmail,
-			Name:    toName,
-		}},
-		Subject: "Your CursorClip Recorder License Key",
-		HTML:    htmlBody,
+	},
+	"invoice": {
+		invoice{
+			Number:        "CC-000042",
+			IssuedAt:      time.Now(),
+			PaymentID:     "pi_3PqR5sT6uV7wX8yZ",
+			CustomerName:  "Jane Doe",
+			CustomerEmail: "jane@example.com",
+			Address:       []string{"1 Main Street", "10115 Berlin", "DE"},
+			Tier:          "pro",
+			Subtotal:      "40.34 EUR",
+			Tax:           "7.66 EUR",
+			Total:         "48.00 EUR",
+		},
+		invoice{
+			Number:        "CC-000043",
+			IssuedAt:      time.Now(),
+			PaymentID:     "pi_3PqR5sT6uV7wX8yZ",
+			CustomerEmail: "jane@example.com",
+			Tier:          "pro",
+			Subtotal:      "48.00 USD",
+			Total:         "48.00 USD",
+		},
+	},
+	"portal_link": {
+		portalLinkEmail{Name: "Jane Doe", Link: "https://example.com/portal?token=sample", ExpiresIn: 15},
+		portalLinkEmail{Link: "https://example.com/portal?token=sample", ExpiresIn: 15},
+	},
+}
+
+// registerMailHooks validates the templates saved from the admin UI by rendering
+// them with sample data, so a typo or a missing field fails the save instead of
+// the next email.
+func registerMailHooks(app core.App) {
+	checkTemplates := func(e *core.RecordEvent) error {
+		samples, ok := emailTemplateSamples[e.Record.GetString("name")]
+		if !ok {
+			return validation.Errors{
+				"name": validation.NewError("validation_unknown_template", "There is no email template with this name."),
+			}
+		}
+
+		errs := validation.Errors{}
+		for _, part := range emailTemplateParts {
+			source := e.Record.GetString(part.field)
+			if source == "" {
+				continue
+			}
+			for _, data := range samples {
+				if err := executeEmailTemplate(io.Discard, part.field, source, data); err != nil {
+					errs[part.field] = validation.NewError("validation_invalid_template", err.Error())
+					break
+				}
+			}
+		}
+		if len(errs) > 0 {
+			return errs
+		}
+		return e.Next()
+	}
+	app.OnRecordCreate("email_templates").BindFunc(checkTemplates)
+	app.OnRecordUpdate("email_templates").BindFunc(checkTemplates)
+}
+
+// userLocale returns the language of the user with email, or defaultLocale.
+func userLocale(app core.App, email string) string {
+	user, err := app.FindFirstRecordByFilter("users", "email = {:email}", dbx.Params{"email": email})
+	if err != nil {
+		return defaultLocale
+	}
+	if language := user.GetString("language"); slices.Contains(supportedLocales, language) {
+		return language
+	}
+	return defaultLocale
+}
+
+// loadEmailTemplate returns the source of each part of the template name, by email_templates field.
+func loadEmailTemplate(app core.App, name, locale string) (map[string]string, error) {
+	locales := []string{locale}
+	if locale != defaultLocale {
+		locales = append(locales, defaultLocale)
+	}
+
+	sources := map[string]string{}
+	for _, locale := range locales {
+		override, err := app.FindFirstRecordByFilter(
+			"email_templates",
+			"name = {:name} && locale = {:locale}",
+			dbx.Params{"name": name, "locale": locale},
+		)
+		if err != nil && !errors.Is(err, sql.ErrNoRows) {
+			return nil, err
+		}
+
+		for _, part := range emailTemplateParts {
+			if _, ok := sources[part.field]; ok {
+				continue
+			}
+
+			if override != nil {
+				if source := override.GetString(part.field); source != "" {
+					sources[part.field] = source
+					continue
+				}
+			}
+
+			filename := name + "." + locale + part.suffix
+			if data, err := os.ReadFile(filepath.Join(app.DataDir(), "emails", filename)); err == nil {
+				sources[part.field] = string(data)
+				continue
+			} else if !errors.Is(err, fs.ErrNotExist) {
+				return nil, err
+			}
+
+			if data, err := defaultEmailTemplates.ReadFile("emails/" + filename); err == nil {
+				sources[part.field] = string(data)
+			}
+		}
+	}
+
+	for _, part := range emailTemplateParts {
+		if _, ok := sources[part.field]; !ok {
+			return nil, fmt.Errorf("email template %s has no %s", name, part.field)
+		}
+	}
+	return sources, nil
+}
+
+// executeEmailTemplate renders one part of a template with data.
+func executeEmailTemplate(w io.Writer, field, source string, data any) error {
+	if field == "html" {
+		tmpl, err := htmltemplate.New(field).Parse(source)
+		if err != nil {
+			return err
+		}
+		return tmpl.Execute(w, data)
+	}
+	tmpl, err := texttemplate.New(field).Parse(source)
+	if err != nil {
+		return err
+	}
+	return tmpl.Execute(w, data)
+}
+
+// renderEmail renders the template name in locale with data.
+func renderEmail(app core.App, name, locale string, data any) (*renderedEmail, error) {
+	sources, err := loadEmailTemplate(app, name, locale)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered := map[string]string{}
+	for _, part := range emailTemplateParts {
+		var buf bytes.Buffer
+		if err := executeEmailTemplate(&buf, part.field, sources[part.field], data); err != nil {
+			return nil, fmt.Errorf("email template %s %s: %w", name, part.field, err)
+		}
+		rendered[part.field] = buf.String()
+	}
+
+	return &renderedEmail{
+		// a subject is a single header line
+		Subject: strings.Join(strings.Fields(rendered["subject"]), " "),
+		HTML:    rendered["html"],
+		Text:    rendered["text"],
+	}, nil
+}
+
+// QueueLicenseEmail queues the welcome/purchase email with the new license key,
+// attaching the invoice of the purchase unless it is nil.
+func QueueLicenseEmail(app core.App, toEmail, toName, key string, invoice *renderedInvoice) error {
+	to := mail.Address{Address: toEmail, Name: toName}
+	data := licenseKeyEmail{Name: toName, Key: key}
+	if invoice == nil {
+		return queueEmail(app, to, "license_key", data)
 	}
 
-	if err := app.NewMailClient().Send(message); err != nil {
-		// Since this runs in a goroutine, we should log errors.
-		log.Printf("ERROR: Failed to send license email to %s: %v", toEmail, err)
+	files, err := invoice.files()
+	if err != nil {
+		return err
 	}
+	data.InvoiceNumber = invoice.Number
+	return queueEmail(app, to, "license_key", data, files...)
+}
+
+// manageDevicesURL reads MANAGE_DEVICES_URL, the page where customers deactivate
+// their devices, and defaults to the customer portal.
+func manageDevicesURL() string {
+	if manageURL := strings.TrimSpace(os.Getenv("MANAGE_DEVICES_URL")); manageURL != "" {
+		return manageURL
+	}
+	return portalURL()
+}
+
+// queueLostLicenseEmail queues the recovery email listing every license of user.
+// Revoked licenses are listed without their key so the customer knows why it stopped working.
+func queueLostLicenseEmail(app core.App, user *core.Record, licenses []*core.Record) error {
+	data := lostLicenseEmail{
+		Name:      user.GetString("name"),
+		ManageURL: manageDevicesURL(),
+	}
+
+	for _, license := range licenses {
+		devices, err := app.CountRecords("devices", dbx.HashExp{"license": license.Id})
+		if err != nil {
+			return err
+		}
+
+		entry := lostLicenseEmailLicense{
+			Status:      license.GetString("status"),
+			Revoked:     license.GetString("status") == "revoked",
+			Tier:        license.GetString("tier"),
+			Devices:     int(devices),
+			DeviceLimit: license.GetInt("activation_limit"),
+			PurchasedAt: license.GetDateTime("created").Time(),
+		}
+		if !entry.Revoked {
+			entry.Key, err = revealLicenseKey(license)
+			if err != nil {
+				app.Logger().Error("Could not decrypt license key", "license", license.Id, "error", err)
+				continue
+			}
+		}
+		data.Licenses = append(data.Licenses, entry)
+	}
+
+	if len(data.Licenses) == 0 {
+		return nil
+	}
+
+	to := mail.Address{Address: user.GetString("email"), Name: user.GetString("name")}
+	return queueEmail(app, to, "lost_license", data)
+}
+
+// queuePortalLinkEmail queues the email with a magic link signing user in to the customer portal.
+func queuePortalLinkEmail(app core.App, user *core.Record) error {
+	link, err := newPortalLink(app, user)
+	if err != nil {
+		return err
+	}
+
+	to := mail.Address{Address: user.Email(), Name: user.GetString("name")}
+	return queueEmail(app, to, "portal_link", portalLinkEmail{
+		Name:      user.GetString("name"),
+		Link:      link,
+		ExpiresIn: int(user.Collection().OTP.DurationTime().Minutes()),
+	})
 }
@@ -0,0 +1,122 @@
+package hooks
+
+import (
+	"io"
+	"io/fs"
+	"strings"
+	"testing"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+)
+
+func TestDefaultEmailTemplatesRenderSamples(t *testing.T) {
+	entries, err := fs.ReadDir(defaultEmailTemplates, "emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, entry := range entries {
+		name, _, _ := strings.Cut(entry.Name(), ".")
+		samples, ok := emailTemplateSamples[name]
+		if !ok {
+			t.Fatalf("%s has no sample data", entry.Name())
+		}
+
+		source, err := fs.ReadFile(defaultEmailTemplates, "emails/"+entry.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		field := "text"
+		if strings.HasSuffix(entry.Name(), ".html") {
+			field = "html"
+		}
+		for _, data := range samples {
+			if err := executeEmailTemplate(io.Discard, field, string(source), data); err != nil {
+				t.Fatalf("%s: %v", entry.Name(), err)
+			}
+		}
+	}
+}
+
+func TestEmailTemplateSampleKeys(t *testing.T) {
+	var keys []string
+	for _, sample := range emailTemplateSamples["license_key"] {
+		keys = append(keys, sample.(licenseKeyEmail).Key)
+	}
+	for _, sample := range emailTemplateSamples["lost_license"] {
+		for _, license := range sample.(lostLicenseEmail).Licenses {
+			if !license.Revoked {
+				keys = append(keys, license.Key)
+			}
+		}
+	}
+
+	for _, key := range keys {
+		if !testKeyPattern.MatchString(key) {
+			t.Errorf("sample key %q doesn't look like a generated key", key)
+		}
+	}
+}
+
+func TestEmailTemplateOverrideValidation(t *testing.T) {
+	app, err := tests.NewTestApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	templates := core.NewBaseCollection("email_templates")
+	templates.Fields.Add(
+		&core.TextField{Name: "name", Required: true},
+		&core.TextField{Name: "locale", Required: true},
+		&core.TextField{Name: "subject"},
+		&core.TextField{Name: "html"},
+		&core.TextField{Name: "text"},
+	)
+	if err := app.Save(templates); err != nil {
+		t.Fatal(err)
+	}
+	registerMailHooks(app)
+
+	scenarios := []struct {
+		name          string
+		template      string
+		field, source string
+		expectedError string // the invalid field, empty when the save succeeds
+	}{
+		{"valid", "license_key", "text", "Your key: {{.Key}}", ""},
+		{"syntax error", "license_key", "text", "Your key: {{.Key}", "text"},
+		{"unknown field", "license_key", "html", "<p>{{.LicenseKey}}</p>", "html"},
+		{"unknown field of another template", "portal_link", "subject", "{{.Key}}", "subject"},
+		{"unknown field in a branch", "license_key", "text", "{{if .InvoiceNumber}}{{.Invoice}}{{end}}", "text"},
+		{"unknown field in the other branch", "lost_license", "text", "{{range .Licenses}}{{if .Revoked}}{{.Reason}}{{end}}{{end}}", "text"},
+		{"unknown template", "welcome", "text", "Hello", "name"},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			record := core.NewRecord(templates)
+			record.Set("name", s.template)
+			record.Set("locale", "en")
+			record.Set(s.field, s.source)
+
+			err := app.Save(record)
+			if s.expectedError == "" {
+				if err != nil {
+					t.Fatalf("expected the template to be saved, got %v", err)
+				}
+				return
+			}
+
+			errs, ok := err.(validation.Errors)
+			if !ok {
+				t.Fatalf("expected validation errors, got %v", err)
+			}
+			if _, ok := errs[s.expectedError]; !ok {
+				t.Fatalf("expected an error for %s, got %v", s.expectedError, errs)
+			}
+		})
+	}
+}
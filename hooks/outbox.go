@@ -0,0 +1,254 @@
+package hooks
+
+import (
+	"fmt"
+	"io"
+	"net/mail"
+	"path"
+	"regexp"
+	"sync"
+	"time"
+
+	validation "github.com/go-ozzo/ozzo-validation/v4"
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/filesystem"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// Emails aren't sent from the request, they are rendered into the "email_outbox"
+// collection and delivered by a background worker, so a SMTP outage or a restart
+// only delays them. Delivery is at least once: a crash between sending and saving
+// the result sends the message again.
+//
+// Failed deliveries are retried with an exponential backoff and the message is
+// dead-lettered after outboxMaxAttempts. Admins resend a message, delivered or
+// dead, by setting its status back to "pending" in the dashboard, which is why
+// the body and attachments are kept after delivery.
+const (
+	outboxPending = "pending"
+	outboxSent    = "sent"
+	outboxDead    = "dead"
+
+	outboxMaxAttempts  = 8
+	outboxBaseBackoff  = time.Minute
+	outboxMaxBackoff   = 6 * time.Hour
+	outboxPollInterval = 30 * time.Second
+	outboxBatchSize    = 50
+)
+
+// storedFileSuffix is the random part PocketBase appends to the stored file names.
+var storedFileSuffix = regexp.MustCompile(`_[a-z0-9]{10}(\.[^.]*)?$`)
+
+// outboxWorker delivers the due messages every outboxPollInterval and right after one is queued.
+type outboxWorker struct {
+	app  core.App
+	kick chan struct{}
+	stop chan struct{}
+	done sync.WaitGroup
+}
+
+// registerOutbox starts the worker together with the server and validates the admin resends.
+func registerOutbox(app core.App) {
+	worker := &outboxWorker{
+		app:  app,
+		kick: make(chan struct{}, 1),
+		stop: make(chan struct{}),
+	}
+
+	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+		worker.done.Add(1)
+		go worker.run()
+		return e.Next()
+	})
+	app.OnTerminate().BindFunc(func(e *core.TerminateEvent) error {
+		close(worker.stop)
+		worker.done.Wait()
+		return e.Next()
+	})
+
+	// runs after the transaction queuing the message commits
+	app.OnRecordAfterCreateSuccess("email_outbox").BindFunc(func(e *core.RecordEvent) error {
+		worker.wake()
+		return e.Next()
+	})
+
+	app.OnRecordUpdate("email_outbox").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("status") == outboxPending && e.Record.Original().GetString("status") != outboxPending {
+			if e.Record.GetString("html") == "" && e.Record.GetString("text") == "" {
+				return validation.Errors{"status": validation.NewError(
+					"validation_outbox_no_body",
+					"The body of this message wasn't kept, it can't be sent again.",
+				)}
+			}
+			e.Record.Set("attempts", 0)
+			e.Record.Set("next_attempt_at", types.NowDateTime())
+			e.Record.Set("last_error", "")
+		}
+		return e.Next()
+	})
+	app.OnRecordAfterUpdateSuccess("email_outbox").BindFunc(func(e *core.RecordEvent) error {
+		if e.Record.GetString("status") == outboxPending {
+			worker.wake()
+		}
+		return e.Next()
+	})
+}
+
+func (w *outboxWorker) wake() {
+	select {
+	case w.kick <- struct{}{}:
+	default: // a delivery run is already due
+	}
+}
+
+func (w *outboxWorker) run() {
+	defer w.done.Done()
+
+	ticker := time.NewTicker(outboxPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := deliverDueEmails(w.app, time.Now()); err != nil {
+			w.app.Logger().Error("Could not deliver the queued emails", "error", err)
+		}
+
+		select {
+		case <-w.stop:
+			return
+		case <-w.kick:
+		case <-ticker.C:
+		}
+	}
+}
+
+// queueEmail renders the template name in the language of the recipient into the outbox.
+func queueEmail(app core.App, to mail.Address, name string, data any, attachments ...*filesystem.File) error {
+	email, err := renderEmail(app, name, userLocale(app, to.Address), data)
+	if err != nil {
+		return err
+	}
+
+	collection, err := app.FindCollectionByNameOrId("email_outbox")
+	if err != nil {
+		return err
+	}
+
+	message := core.NewRecord(collection)
+	message.Set("to_email", to.Address)
+	message.Set("to_name", to.Name)
+	message.Set("template", name)
+	message.Set("subject", email.Subject)
+	message.Set("html", email.HTML)
+	message.Set("text", email.Text)
+	message.Set("status", outboxPending)
+	message.Set("next_attempt_at", types.NowDateTime())
+	if len(attachments) > 0 {
+		message.Set("attachments", attachments)
+	}
+
+	return app.Save(message)
+}
+
+// outboxBackoff is the delay before retrying a message that failed attempts times.
+func outboxBackoff(attempts int) time.Duration {
+	backoff := outboxBaseBackoff
+	for i := 1; i < attempts && backoff < outboxMaxBackoff; i++ {
+		backoff *= 2
+	}
+	return min(backoff, outboxMaxBackoff)
+}
+
+// deliverDueEmails tries to send every pending message whose next attempt is due at now.
+func deliverDueEmails(app core.App, now time.Time) error {
+	for {
+		messages, err := app.FindRecordsByFilter(
+			"email_outbox",
+			"status = {:status} && next_attempt_at <= {:now}",
+			"next_attempt_at",
+			outboxBatchSize, 0,
+			dbx.Params{"status": outboxPending, "now": now.UTC().Format(types.DefaultDateLayout)},
+		)
+		if err != nil {
+			return err
+		}
+
+		for _, message := range messages {
+			if err := deliverEmail(app, message, now); err != nil {
+				return err
+			}
+		}
+
+		if len(messages) < outboxBatchSize {
+			return nil
+		}
+	}
+}
+
+// deliverEmail sends one message and records the outcome on it.
+func deliverEmail(app core.App, message *core.Record, now time.Time) error {
+	sendErr := sendOutboxMessage(app, message)
+
+	attempts := message.GetInt("attempts") + 1
+	message.Set("attempts", attempts)
+
+	switch {
+	case sendErr == nil:
+		message.Set("status", outboxSent)
+		message.Set("sent_at", now.UTC())
+		message.Set("last_error", "")
+	case attempts >= outboxMaxAttempts:
+		message.Set("status", outboxDead)
+		message.Set("last_error", sendErr.Error())
+		app.Logger().Error("Giving up on email", "message", message.Id, "email", message.GetString("to_email"), "attempts", attempts, "error", sendErr)
+	default:
+		message.Set("next_attempt_at", now.UTC().Add(outboxBackoff(attempts)))
+		message.Set("last_error", sendErr.Error())
+		app.Logger().Warn("Email delivery failed, will retry", "message", message.Id, "attempts", attempts, "error", sendErr)
+	}
+
+	return app.Save(message)
+}
+
+// sendOutboxMessage sends message with its attachments read from the storage.
+func sendOutboxMessage(app core.App, message *core.Record) error {
+	attachments := map[string]io.Reader{}
+	if names := message.GetStringSlice("attachments"); len(names) > 0 {
+		fsys, err := app.NewFilesystem()
+		if err != nil {
+			return err
+		}
+		defer fsys.Close()
+
+		for _, name := range names {
+			reader, err := fsys.GetReader(message.BaseFilesPath() + "/" + name)
+			if err != nil {
+				return fmt.Errorf("reading attachment %s: %w", name, err)
+			}
+			defer reader.Close()
+			attachments[attachmentName(name)] = reader
+		}
+	}
+
+	return app.NewMailClient().Send(&mailer.Message{
+		From: mail.Address{
+			Address: app.Settings().Meta.SenderAddress,
+			Name:    app.Settings().Meta.SenderName,
+		},
+		To: []mail.Address{{
+			Address: message.GetString("to_email"),
+			Name:    message.GetString("to_name"),
+		}},
+		Subject:     message.GetString("subject"),
+		HTML:        message.GetString("html"),
+		Text:        message.GetString("text"),
+		Attachments: attachments,
+	})
+}
+
+// attachmentName strips the random suffix from a stored file name, e.g.
+// invoice_cc_000042_0123456789.pdf becomes invoice_cc_000042.pdf.
+func attachmentName(stored string) string {
+	return storedFileSuffix.ReplaceAllString(path.Base(stored), "$1")
+}
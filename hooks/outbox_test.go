@@ -0,0 +1,327 @@
+package hooks
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/mail"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tests"
+	"github.com/pocketbase/pocketbase/tools/mailer"
+)
+
+// fakeMailer records the sent messages and fails the next failures sends.
+type fakeMailer struct {
+	failures int
+	attempts int
+	sent     []*mailer.Message
+}
+
+func (m *fakeMailer) Send(message *mailer.Message) error {
+	m.attempts++
+	if m.failures > 0 {
+		m.failures--
+		return errors.New("smtp: 421 service not available")
+	}
+	// the attachment readers are closed once Send returns
+	for name, reader := range message.Attachments {
+		data, err := io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+		message.Attachments[name] = bytes.NewReader(data)
+	}
+	m.sent = append(m.sent, message)
+	return nil
+}
+
+// newOutboxTestApp creates a test app with the email collections and the outbox hooks,
+// sending through the returned fake mailer.
+func newOutboxTestApp(t *testing.T) (*tests.TestApp, *fakeMailer) {
+	t.Helper()
+
+	app, err := tests.NewTestApp()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(app.Cleanup)
+
+	templates := core.NewBaseCollection("email_templates")
+	templates.Fields.Add(
+		&core.TextField{Name: "name"},
+		&core.TextField{Name: "locale"},
+		&core.TextField{Name: "subject"},
+		&core.TextField{Name: "html"},
+		&core.TextField{Name: "text"},
+	)
+	if err := app.Save(templates); err != nil {
+		t.Fatal(err)
+	}
+
+	outbox := core.NewBaseCollection("email_outbox")
+	outbox.Fields.Add(
+		&core.TextField{Name: "to_email"},
+		&core.TextField{Name: "to_name"},
+		&core.TextField{Name: "template"},
+		&core.TextField{Name: "subject"},
+		&core.TextField{Name: "html", Max: 1000000},
+		&core.TextField{Name: "text", Max: 1000000},
+		&core.TextField{Name: "status"},
+		&core.NumberField{Name: "attempts", OnlyInt: true},
+		&core.DateField{Name: "next_attempt_at"},
+		&core.TextField{Name: "last_error"},
+		&core.DateField{Name: "sent_at"},
+		&core.FileField{Name: "attachments", MaxSelect: 5, MaxSize: 10 << 20, Protected: true},
+	)
+	if err := app.Save(outbox); err != nil {
+		t.Fatal(err)
+	}
+
+	registerOutbox(app)
+
+	fake := &fakeMailer{}
+	app.OnMailerSend().BindFunc(func(e *core.MailerEvent) error {
+		e.Mailer = fake
+		return e.Next()
+	})
+
+	return app, fake
+}
+
+func queueTestEmail(t *testing.T, app core.App) *core.Record {
+	t.Helper()
+
+	to := mail.Address{Address: "customer@example.com", Name: "Ann <b>"}
+	if err := QueueLicenseEmail(app, to.Address, to.Name, "ABC-DEF", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	message, err := app.FindFirstRecordByData("email_outbox", "to_email", to.Address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func reloadMessage(t *testing.T, app core.App, message *core.Record) *core.Record {
+	t.Helper()
+
+	message, err := app.FindRecordById("email_outbox", message.Id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return message
+}
+
+func TestDeliverDueEmailsSendsOnce(t *testing.T) {
+	app, fake := newOutboxTestApp(t)
+	message := queueTestEmail(t, app)
+	now := time.Now()
+
+	for range 3 {
+		if err := deliverDueEmails(app, now); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(fake.sent) != 1 {
+		t.Fatalf("expected 1 sent message, got %d", len(fake.sent))
+	}
+	sent := fake.sent[0]
+	if sent.To[0].Address != "customer@example.com" || sent.Text == "" || sent.HTML == "" {
+		t.Fatalf("unexpected message %+v", sent)
+	}
+
+	message = reloadMessage(t, app, message)
+	if status := message.GetString("status"); status != outboxSent {
+		t.Fatalf("expected status %q, got %q", outboxSent, status)
+	}
+	if message.GetInt("attempts") != 1 || message.GetDateTime("sent_at").IsZero() {
+		t.Fatalf("expected 1 attempt and sent_at, got %d and %v", message.GetInt("attempts"), message.GetDateTime("sent_at"))
+	}
+	if message.GetString("html") == "" || message.GetString("text") == "" {
+		t.Fatal("expected the body of the delivered message to be kept for a resend")
+	}
+}
+
+func TestDeliverDueEmailsAttachments(t *testing.T) {
+	app, fake := newOutboxTestApp(t)
+
+	invoice := &renderedInvoice{Number: "CC-000042", HTML: []byte("<p>invoice</p>"), PDF: []byte("%PDF-1.4")}
+	if err := QueueLicenseEmail(app, "customer@example.com", "", "ABC-DEF", invoice); err != nil {
+		t.Fatal(err)
+	}
+	message, err := app.FindFirstRecordByData("email_outbox", "to_email", "customer@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(message.GetStringSlice("attachments")) != 2 {
+		t.Fatalf("expected 2 stored attachments, got %v", message.GetStringSlice("attachments"))
+	}
+
+	if err := deliverDueEmails(app, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fake.sent) != 1 {
+		t.Fatalf("expected 1 sent message, got %d", len(fake.sent))
+	}
+	attachments := fake.sent[0].Attachments
+	pdf, ok := attachments["invoice_cc_000042.pdf"]
+	if !ok || len(attachments) != 2 {
+		t.Fatalf("expected the html and pdf invoice attachments, got %v", attachments)
+	}
+	if data, err := io.ReadAll(pdf); err != nil || string(data) != "%PDF-1.4" {
+		t.Fatalf("unexpected pdf attachment %q, %v", data, err)
+	}
+
+	message = reloadMessage(t, app, message)
+	if len(message.GetStringSlice("attachments")) != 2 {
+		t.Fatal("expected the attachments of the delivered message to be kept for a resend")
+	}
+}
+
+func TestDeliverDueEmailsRetriesWithBackoff(t *testing.T) {
+	app, fake := newOutboxTestApp(t)
+	fake.failures = 2
+	message := queueTestEmail(t, app)
+	now := time.Now()
+
+	// first attempt fails, the retry is due a minute later
+	if err := deliverDueEmails(app, now); err != nil {
+		t.Fatal(err)
+	}
+	message = reloadMessage(t, app, message)
+	if message.GetString("status") != outboxPending || message.GetString("last_error") == "" {
+		t.Fatalf("expected a pending message with the error, got %q %q", message.GetString("status"), message.GetString("last_error"))
+	}
+
+	// not due yet
+	if err := deliverDueEmails(app, now.Add(outboxBackoff(1)-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if fake.attempts != 1 {
+		t.Fatalf("expected no retry before the backoff, got %d attempts", fake.attempts)
+	}
+
+	// second attempt fails, the backoff doubles
+	now = now.Add(outboxBackoff(1))
+	if err := deliverDueEmails(app, now); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliverDueEmails(app, now.Add(outboxBackoff(2)-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if fake.attempts != 2 {
+		t.Fatalf("expected 2 attempts, got %d", fake.attempts)
+	}
+
+	now = now.Add(outboxBackoff(2))
+	if err := deliverDueEmails(app, now); err != nil {
+		t.Fatal(err)
+	}
+	message = reloadMessage(t, app, message)
+	if len(fake.sent) != 1 || message.GetString("status") != outboxSent || message.GetInt("attempts") != 3 {
+		t.Fatalf("expected delivery on the 3rd attempt, got %d sent, status %q, %d attempts",
+			len(fake.sent), message.GetString("status"), message.GetInt("attempts"))
+	}
+}
+
+func TestDeliverDueEmailsDeadLetters(t *testing.T) {
+	app, fake := newOutboxTestApp(t)
+	fake.failures = 1000
+	message := queueTestEmail(t, app)
+	now := time.Now()
+
+	for range outboxMaxAttempts + 2 {
+		if err := deliverDueEmails(app, now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(outboxMaxBackoff)
+	}
+
+	message = reloadMessage(t, app, message)
+	if status := message.GetString("status"); status != outboxDead {
+		t.Fatalf("expected status %q, got %q", outboxDead, status)
+	}
+	if fake.attempts != outboxMaxAttempts {
+		t.Fatalf("expected %d attempts, got %d", outboxMaxAttempts, fake.attempts)
+	}
+	if message.GetString("text") == "" {
+		t.Fatal("expected the body of the dead letter to be kept for a resend")
+	}
+}
+
+func TestOutboxResend(t *testing.T) {
+	app, fake := newOutboxTestApp(t)
+	fake.failures = outboxMaxAttempts
+	message := queueTestEmail(t, app)
+	now := time.Now()
+
+	for range outboxMaxAttempts {
+		if err := deliverDueEmails(app, now); err != nil {
+			t.Fatal(err)
+		}
+		now = now.Add(outboxMaxBackoff)
+	}
+
+	// an admin sends the dead letter again once SMTP is fixed
+	message = reloadMessage(t, app, message)
+	message.Set("status", outboxPending)
+	if err := app.Save(message); err != nil {
+		t.Fatal(err)
+	}
+	if message.GetInt("attempts") != 0 || message.GetString("last_error") != "" {
+		t.Fatalf("expected the resend to reset the attempts, got %d %q", message.GetInt("attempts"), message.GetString("last_error"))
+	}
+
+	if err := deliverDueEmails(app, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	message = reloadMessage(t, app, message)
+	if len(fake.sent) != 1 || message.GetString("status") != outboxSent {
+		t.Fatalf("expected the resend to be delivered, got %d sent, status %q", len(fake.sent), message.GetString("status"))
+	}
+
+	// a delivered message can be sent again too, e.g. when the customer lost it
+	message.Set("status", outboxPending)
+	if err := app.Save(message); err != nil {
+		t.Fatal(err)
+	}
+	if err := deliverDueEmails(app, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	message = reloadMessage(t, app, message)
+	if len(fake.sent) != 2 || message.GetString("status") != outboxSent {
+		t.Fatalf("expected the delivered message to be sent again, got %d sent, status %q", len(fake.sent), message.GetString("status"))
+	}
+	if fake.sent[1].Text != fake.sent[0].Text || fake.sent[1].Subject != fake.sent[0].Subject {
+		t.Fatal("expected the same message to be sent again")
+	}
+
+	// messages delivered while the body was cleared on delivery can't be resent
+	message.Set("html", "")
+	message.Set("text", "")
+	if err := app.Save(message); err != nil {
+		t.Fatal(err)
+	}
+	message.Set("status", outboxPending)
+	if err := app.Save(message); err == nil {
+		t.Fatal("expected resending a message without a body to fail")
+	}
+}
+
+func TestOutboxBackoff(t *testing.T) {
+	expected := []time.Duration{time.Minute, 2 * time.Minute, 4 * time.Minute, 8 * time.Minute}
+	for i, backoff := range expected {
+		if actual := outboxBackoff(i + 1); actual != backoff {
+			t.Errorf("attempt %d: expected %v, got %v", i+1, backoff, actual)
+		}
+	}
+	if actual := outboxBackoff(100); actual != outboxMaxBackoff {
+		t.Errorf("expected the backoff to be capped at %v, got %v", outboxMaxBackoff, actual)
+	}
+}
@@ -0,0 +1,151 @@
+package hooks
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// A4 page layout of renderTextPDF, in points.
+const (
+	pdfPageWidth  = 595.28
+	pdfPageHeight = 841.89
+	pdfMargin     = 56
+	pdfFontSize   = 10
+	pdfLeading    = 14
+
+	// what fits between the margins, Courier glyphs being 0.6 em wide
+	pdfColumns = 80
+	pdfRows    = 52
+)
+
+// pdfWinAnsi maps the non Latin-1 characters of Windows-1252.
+var pdfWinAnsi = map[rune]byte{
+	'€': 0x80, '‚': 0x82, '„': 0x84, '…': 0x85, '‘': 0x91, '’': 0x92,
+	'“': 0x93, '”': 0x94, '•': 0x95, '–': 0x96, '—': 0x97, '™': 0x99,
+}
+
+type pdfLine struct {
+	text string
+	bold bool
+}
+
+// renderTextPDF lays out plain text on A4 pages in Courier. It only relies on the
+// standard fonts every PDF reader ships, so nothing is embedded and the monospaced
+// layout of the text template is kept as is. Lines starting with "# " are set in bold.
+// Characters outside of Windows-1252 are replaced with "?".
+func renderTextPDF(title, text string, now time.Time) ([]byte, error) {
+	var lines []pdfLine
+	for _, raw := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+		line := pdfLine{text: strings.ReplaceAll(strings.TrimRight(raw, "\r"), "\t", "    ")}
+		if heading, ok := strings.CutPrefix(line.text, "# "); ok {
+			line = pdfLine{text: heading, bold: true}
+		}
+
+		// hard wrap, the text templates are expected to fit already
+		runes := []rune(line.text)
+		for len(runes) > pdfColumns {
+			lines = append(lines, pdfLine{text: string(runes[:pdfColumns]), bold: line.bold})
+			runes = runes[pdfColumns:]
+		}
+		lines = append(lines, pdfLine{text: string(runes), bold: line.bold})
+	}
+
+	var pages [][]pdfLine
+	for len(lines) > pdfRows {
+		pages = append(pages, lines[:pdfRows])
+		lines = lines[pdfRows:]
+	}
+	pages = append(pages, lines)
+
+	// objects 1-5 are fixed, each page then adds its page and content objects
+	objects := []string{
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		"", // pages, once the page ids are known
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier-Bold /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Title (%s) /Producer (cc-hub) /CreationDate (D:%s) >>",
+			pdfString(title), now.UTC().Format("20060102150405Z")),
+	}
+
+	var kids []string
+	for _, page := range pages {
+		var content bytes.Buffer
+		fmt.Fprintf(&content, "BT\n%d TL\n%d %.2f Td\n", pdfLeading, pdfMargin, pdfPageHeight-pdfMargin-pdfFontSize)
+		for _, line := range page {
+			font := "F1"
+			if line.bold {
+				font = "F2"
+			}
+			fmt.Fprintf(&content, "/%s %d Tf (%s) Tj T*\n", font, pdfFontSize, pdfString(line.text))
+		}
+		content.WriteString("ET")
+
+		var compressed bytes.Buffer
+		zw := zlib.NewWriter(&compressed)
+		if _, err := zw.Write(content.Bytes()); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+
+		pageID := len(objects) + 1
+		kids = append(kids, fmt.Sprintf("%d 0 R", pageID))
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %.2f %.2f] /Resources << /Font << /F1 3 0 R /F2 4 0 R >> >> /Contents %d 0 R >>",
+				pdfPageWidth, pdfPageHeight, pageID+1),
+			fmt.Sprintf("<< /Length %d /Filter /FlateDecode >>\nstream\n%s\nendstream", compressed.Len(), compressed.Bytes()),
+		)
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(kids))
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 5 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return out.Bytes(), nil
+}
+
+// pdfString encodes s to Windows-1252 and escapes it for a PDF literal string.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		var c byte
+		switch {
+		case r < 0x80:
+			c = byte(r)
+		case r >= 0xa0 && r <= 0xff:
+			c = byte(r)
+		default:
+			var ok bool
+			if c, ok = pdfWinAnsi[r]; !ok {
+				c = '?'
+			}
+		}
+
+		switch {
+		case c == '(' || c == ')' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c < 0x20 || c >= 0x7f:
+			fmt.Fprintf(&b, "\\%03o", c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,266 @@
+package hooks
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// The customer portal signs customers in with a magic link instead of a password.
+// portal/login emails a link to PORTAL_URL carrying a token signed with the user's
+// token key, and portal/auth exchanges it for a regular users auth token. The token
+// points to an OTP record that is deleted on first use, so each link works once
+// and only for the OTP duration of the users collection.
+//
+// Once signed in, customers read their licenses and devices, and rename or remove
+// devices, through the record APIs. The collection rules only let them see and
+// change their own records. The routes below cover what the rules can't express.
+
+// portalLinkTokenType is the type claim of the magic link tokens.
+const portalLinkTokenType = "portalLink"
+
+var errInvalidPortalLink = errors.New("invalid or expired portal link")
+
+// portalURL reads PORTAL_URL, the customer portal page the magic links open.
+// The portal is disabled when it isn't set.
+func portalURL() string {
+	return strings.TrimSpace(os.Getenv("PORTAL_URL"))
+}
+
+// registerPortalHooks frees the seat of the devices customers remove from the portal.
+func registerPortalHooks(app core.App) {
+	// Customers may delete their devices by the collection rule, but it has to go
+	// through the deactivation limits and log like /deactivate does.
+	app.OnRecordDeleteRequest("devices").BindFunc(func(e *core.RecordRequestEvent) error {
+		if e.HasSuperuserAuth() {
+			return e.Next()
+		}
+
+		license, err := e.App.FindRecordById("licenses", e.Record.GetString("license"))
+		if err != nil {
+			return apis.NewNotFoundError("License not found.", err)
+		}
+
+		deviceID := e.Record.GetString("device_id")
+		_, err = deactivateDevice(e.App, license, deviceID, e.RealIP(), time.Now())
+		if errors.Is(err, errDeactivationCooldown) || errors.Is(err, errDeactivationLimit) {
+			e.App.Logger().Warn("Deactivation refused", "license", license.Id, "deviceId", deviceID, "error", err.Error())
+			return apis.NewApiError(http.StatusTooManyRequests, "Too many deactivations for this license, please try again later.", nil)
+		}
+		if err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Could not deactivate device.", err)
+		}
+
+		return e.NoContent(http.StatusNoContent)
+	})
+}
+
+// portalLinkSigningKey is the key the magic links of user are signed with. It
+// contains the user token key, so the links stop working when it is regenerated.
+func portalLinkSigningKey(user *core.Record) string {
+	return user.TokenKey() + user.Collection().VerificationToken.Secret
+}
+
+// newPortalLink creates a one-time magic link signing user in to the portal.
+func newPortalLink(app core.App, user *core.Record) (string, error) {
+	base, err := url.Parse(portalURL())
+	if err != nil {
+		return "", err
+	}
+
+	code := security.RandomString(32)
+	otp := core.NewOTP(app)
+	otp.SetCollectionRef(user.Collection().Id)
+	otp.SetRecordRef(user.Id)
+	otp.SetSentTo(user.Email())
+	otp.SetPassword(code)
+	if err := app.Save(otp); err != nil {
+		return "", err
+	}
+
+	token, err := security.NewJWT(map[string]any{
+		core.TokenClaimType:         portalLinkTokenType,
+		core.TokenClaimId:           user.Id,
+		core.TokenClaimCollectionId: user.Collection().Id,
+		"otpId":                     otp.Id,
+		"code":                      code,
+	}, portalLinkSigningKey(user), user.Collection().OTP.DurationTime())
+	if err != nil {
+		return "", err
+	}
+
+	query := base.Query()
+	query.Set("token", token)
+	base.RawQuery = query.Encode()
+	return base.String(), nil
+}
+
+// usePortalLink verifies a magic link token and deletes its OTP so it can't be used again.
+func usePortalLink(app core.App, token string) (*core.Record, *core.OTP, error) {
+	claims, err := security.ParseUnverifiedJWT(token)
+	if err != nil {
+		return nil, nil, errInvalidPortalLink
+	}
+	if claims[core.TokenClaimType] != portalLinkTokenType {
+		return nil, nil, errInvalidPortalLink
+	}
+	userID, _ := claims[core.TokenClaimId].(string)
+
+	user, err := app.FindRecordById("users", userID)
+	if err != nil {
+		return nil, nil, errInvalidPortalLink
+	}
+
+	claims, err = security.ParseJWT(token, portalLinkSigningKey(user))
+	if err != nil {
+		return nil, nil, errInvalidPortalLink
+	}
+	otpID, _ := claims["otpId"].(string)
+	code, _ := claims["code"].(string)
+
+	var otp *core.OTP
+	err = app.RunInTransaction(func(txApp core.App) error {
+		// read and delete in one transaction, so concurrent requests can't both use the link
+		found, err := txApp.FindOTPById(otpID)
+		if err != nil {
+			return errInvalidPortalLink
+		}
+		otp = found
+		if otp.RecordRef() != user.Id || otp.CollectionRef() != user.Collection().Id ||
+			otp.HasExpired(user.Collection().OTP.DurationTime()) || !otp.ValidatePassword(code) {
+			return errInvalidPortalLink
+		}
+		return txApp.Delete(otp)
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return user, otp, nil
+}
+
+// handlePortalLogin emails a magic link to the customer. Like /request_license,
+// it answers the same whether or not the email belongs to a customer.
+func handlePortalLogin(app core.App) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		payload := struct {
+			Email string `json:"email"`
+		}{}
+		if err := e.BindBody(&payload); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+		if portalURL() == "" {
+			return apis.NewNotFoundError("The customer portal isn't enabled.", nil)
+		}
+
+		sanitizedEmail := strings.ToLower(strings.TrimSpace(payload.Email))
+
+		user, err := e.App.FindFirstRecordByFilter("users", "email = {:email}", map[string]any{"email": sanitizedEmail})
+		if err != nil {
+			return e.JSON(http.StatusOK, map[string]string{"status": "ok"})
+		}
+
+		if err := queuePortalLinkEmail(e.App, user); err != nil {
+			e.App.Logger().Error("Could not queue the portal link email", "email", sanitizedEmail, "error", err)
+		}
+
+		return e.JSON(http.StatusOK, map[string]string{"status": "ok"})
+	}
+}
+
+// handlePortalAuth exchanges a magic link token for a users auth token.
+func handlePortalAuth(app core.App) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		payload := struct {
+			Token string `json:"token"`
+		}{}
+		if err := e.BindBody(&payload); err != nil {
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+
+		user, otp, err := usePortalLink(e.App, payload.Token)
+		if errors.Is(err, errInvalidPortalLink) {
+			return apis.NewBadRequestError("This link is invalid or has expired, please request a new one.", nil)
+		}
+		if err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Could not sign in.", err)
+		}
+
+		// following the link proves the customer owns the email address
+		if !user.Verified() && otp.SentTo() == user.Email() {
+			user.SetVerified(true)
+			if err := e.App.Save(user); err != nil {
+				e.App.Logger().Error("Could not mark the user as verified", "user", user.Id, "error", err)
+			}
+		}
+
+		return apis.RecordAuthResponse(e, user, core.MFAMethodOTP, nil)
+	}
+}
+
+// findPortalLicense returns the license of the request path, if the signed in
+// customer may view it by the licenses view rule.
+func findPortalLicense(e *core.RequestEvent) (*core.Record, error) {
+	license, err := e.App.FindRecordById("licenses", e.Request.PathValue("id"))
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := e.RequestInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	ok, err := e.App.CanAccessRecord(license, info, license.Collection().ViewRule)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("the license belongs to another user")
+	}
+	return license, nil
+}
+
+// handlePortalInvoice downloads the invoice of a license of the signed in customer.
+func handlePortalInvoice(app core.App) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		license, err := findPortalLicense(e)
+		if err != nil {
+			return apis.NewNotFoundError("License not found.", nil)
+		}
+
+		return serveInvoice(e, license, e.Request.URL.Query().Get("format"))
+	}
+}
+
+// handlePortalResendKey emails a license key of the signed in customer again,
+// with the recovery email of /request_license listing only that license.
+func handlePortalResendKey(app core.App) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		license, err := findPortalLicense(e)
+		if err != nil {
+			return apis.NewNotFoundError("License not found.", nil)
+		}
+		if license.GetString("status") == "revoked" {
+			return apis.NewBadRequestError("This license has been revoked.", nil)
+		}
+
+		// queueLostLicenseEmail skips the keys it can't decrypt, fail the request instead
+		if _, err := revealLicenseKey(license); err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Could not send the license key.", err)
+		}
+
+		if err := queueLostLicenseEmail(e.App, e.Auth, []*core.Record{license}); err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Could not send the license key.", err)
+		}
+
+		return e.JSON(http.StatusOK, map[string]string{"status": "success"})
+	}
+}
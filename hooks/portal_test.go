@@ -0,0 +1,128 @@
+package hooks
+
+import (
+	"net/http"
+	"net/url"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+var testPortalLinkPattern = regexp.MustCompile(`https://portal\.example\.com/\?token=[^\s"<]+`)
+
+// portalLinkTestToken returns the token of a magic link.
+func portalLinkTestToken(t *testing.T, link string) string {
+	t.Helper()
+
+	parsed, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return parsed.Query().Get("token")
+}
+
+func TestPortalLinkWorksOnce(t *testing.T) {
+	t.Setenv("PORTAL_URL", "https://portal.example.com/")
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+	user := newTestUser(t, app, "portal@example.com")
+
+	decodeTestResponse(t, sendTestRequest(router, http.MethodPost, "/api/v1/portal/login", map[string]any{"email": "portal@example.com"}, nil), http.StatusOK, nil)
+	message, err := app.FindFirstRecordByData("email_outbox", "to_email", "portal@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	link := testPortalLinkPattern.FindString(message.GetString("text"))
+	if link == "" {
+		t.Fatalf("expected a magic link in %q", message.GetString("text"))
+	}
+	token := portalLinkTestToken(t, link)
+
+	var auth struct {
+		Token  string `json:"token"`
+		Record struct {
+			ID       string `json:"id"`
+			Verified bool   `json:"verified"`
+		} `json:"record"`
+	}
+	decodeTestResponse(t, sendTestRequest(router, http.MethodPost, "/api/v1/portal/auth", map[string]any{"token": token}, nil), http.StatusOK, &auth)
+	if auth.Token == "" || auth.Record.ID != user.Id || !auth.Record.Verified {
+		t.Fatalf("expected a verified sign in of %s, got %+v", user.Id, auth)
+	}
+
+	rec := sendTestRequest(router, http.MethodPost, "/api/v1/portal/auth", map[string]any{"token": token}, nil)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+}
+
+func TestPortalLinkConcurrentUse(t *testing.T) {
+	t.Setenv("PORTAL_URL", "https://portal.example.com/")
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	link, err := newPortalLink(app, newTestUser(t, app, "race@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := portalLinkTestToken(t, link)
+
+	var mu sync.Mutex
+	codes := map[int]int{}
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			rec := sendTestRequest(router, http.MethodPost, "/api/v1/portal/auth", map[string]any{"token": token}, nil)
+			mu.Lock()
+			codes[rec.Code]++
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+
+	if codes[http.StatusOK] != 1 || codes[http.StatusBadRequest] != 4 {
+		t.Fatalf("expected exactly one sign in, got %v", codes)
+	}
+}
+
+func TestPortalLinkExpires(t *testing.T) {
+	t.Setenv("PORTAL_URL", "https://portal.example.com/")
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+	user := newTestUser(t, app, "expired@example.com")
+
+	link, err := newPortalLink(app, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := portalLinkTestToken(t, link)
+
+	// The link was sent longer ago than the OTP duration of the users collection.
+	sentAt := time.Now().Add(-user.Collection().OTP.DurationTime() - time.Minute)
+	created, err := types.ParseDateTime(sentAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := app.DB().Update("_otps", dbx.Params{"created": created.String()}, dbx.HashExp{"recordRef": user.Id}).Execute()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, _ := result.RowsAffected(); n != 1 {
+		t.Fatalf("expected to backdate 1 OTP, got %d", n)
+	}
+
+	rec := sendTestRequest(router, http.MethodPost, "/api/v1/portal/auth", map[string]any{"token": token}, nil)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+
+	// A tampered token is refused too.
+	link, err = newPortalLink(app, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rec = sendTestRequest(router, http.MethodPost, "/api/v1/portal/auth", map[string]any{"token": portalLinkTestToken(t, link) + "x"}, nil)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+}
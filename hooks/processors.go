@@ -0,0 +1,384 @@
+package hooks
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+	"github.com/pocketbase/pocketbase/forms"
+	"github.com/pocketbase/pocketbase/tools/security"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+// defaultActivationLimit is the number of devices a newly issued license can be activated on.
+const defaultActivationLimit = 3
+
+// paymentProcessor adapts a payment provider's webhooks to our license pipeline.
+type paymentProcessor interface {
+	// Name is the value stored in the transactions "processor" field.
+	Name() string
+
+	// VerifyRequest authenticates a raw webhook delivery.
+	VerifyRequest(header http.Header, body []byte, now time.Time) error
+
+	// ParseEvent decodes a verified webhook body into a processorEvent.
+	ParseEvent(body []byte) (*processorEvent, error)
+
+	// Purchase maps a completed checkout event to a normalized purchase.
+	// It returns nil for events that don't issue a license.
+	Purchase(event *processorEvent) (*purchase, error)
+
+	// Reversal maps refund and dispute events to a normalized reversal.
+	// It returns nil for events that don't affect an issued license.
+	Reversal(event *processorEvent) (*reversal, error)
+}
+
+// processorEvent is the processor-agnostic envelope of a webhook delivery.
+type processorEvent struct {
+	Type    string
+	Data    json.RawMessage
+	Payload map[string]any // the full decoded body, kept on the transaction for debugging
+}
+
+// purchase is a completed checkout, normalized across processors.
+type purchase struct {
+	ProcessorID   string // the payment id at the processor, used for idempotency
+	CustomerEmail string
+	CustomerName  string
+
+	// Amount is the total paid, tax included, in the minor unit of Currency.
+	Amount         int64
+	Tax            int64
+	Currency       string // ISO 4217, upper case
+	BillingAddress billingAddress
+}
+
+// invoiceFields are the transaction fields of the invoice details of p.
+func (p *purchase) invoiceFields() map[string]any {
+	return map[string]any{
+		"amount":          p.Amount,
+		"tax_amount":      p.Tax,
+		"currency":        strings.ToUpper(p.Currency),
+		"billing_address": p.BillingAddress,
+	}
+}
+
+// purchaseFromTransaction rebuilds the purchase a transaction was recorded from.
+func purchaseFromTransaction(transaction *core.Record) (*purchase, error) {
+	p := &purchase{
+		ProcessorID:   transaction.GetString("processor_id"),
+		CustomerEmail: transaction.GetString("user_email"),
+		CustomerName:  transaction.GetString("user_name"),
+		Amount:        int64(transaction.GetInt("amount")),
+		Tax:           int64(transaction.GetInt("tax_amount")),
+		Currency:      transaction.GetString("currency"),
+	}
+	if transaction.GetString("billing_address") != "" {
+		if err := transaction.UnmarshalJSONField("billing_address", &p.BillingAddress); err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// decodeProcessorEvent unmarshals body into the processor specific envelope
+// and keeps a generic copy of the whole payload.
+func decodeProcessorEvent(body []byte, envelope any) (map[string]any, error) {
+	if err := json.Unmarshal(body, envelope); err != nil {
+		return nil, err
+	}
+	payload := map[string]any{}
+	if err := json.Unmarshal(body, &payload); err != nil {
+		return nil, err
+	}
+	return payload, nil
+}
+
+// handlePaymentWebhook verifies and dispatches the webhook deliveries of a single processor.
+func handlePaymentWebhook(app core.App, processor paymentProcessor) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		body, err := io.ReadAll(e.Request.Body)
+		if err != nil {
+			return apis.NewBadRequestError("Failed to read request body", err)
+		}
+
+		if err := processor.VerifyRequest(e.Request.Header, body, time.Now()); err != nil {
+			if errors.Is(err, errWebhookNoSecret) {
+				app.Logger().Error("Webhook secret is not configured", "processor", processor.Name())
+				return apis.NewApiError(http.StatusInternalServerError, "Webhook verification is not configured", nil)
+			}
+			// Keep a trace of every rejected delivery so forged attempts show up in the logs.
+			app.Logger().Warn(
+				"Rejected webhook delivery",
+				"processor", processor.Name(),
+				"error", err.Error(),
+				"ip", e.RealIP(),
+			)
+			return apis.NewUnauthorizedError("Invalid webhook signature", nil)
+		}
+
+		event, err := processor.ParseEvent(body)
+		if err != nil {
+			return apis.NewBadRequestError("Invalid payload", err)
+		}
+
+		p, err := processor.Purchase(event)
+		if err != nil {
+			return apis.NewBadRequestError("Invalid payload", err)
+		}
+		if p != nil {
+			return processPurchase(app, e, processor.Name(), p, event.Payload)
+		}
+
+		r, err := processor.Reversal(event)
+		if err != nil {
+			return apis.NewBadRequestError("Invalid payload", err)
+		}
+		if r != nil {
+			return processReversal(app, e, processor.Name(), r)
+		}
+
+		// Not an event we act on; acknowledge it so the processor doesn't retry.
+		return e.NoContent(http.StatusOK)
+	}
+}
+
+// issuedLicense is the outcome of a fulfilled purchase.
+type issuedLicense struct {
+	License *core.Record
+	Key     string
+	Email   string
+	Name    string
+}
+
+// processPurchase fulfills a purchase webhook, which queues the email with the new key to the customer.
+func processPurchase(app core.App, e *core.RequestEvent, processorName string, p *purchase, payload any) error {
+	if p.ProcessorID == "" || p.CustomerEmail == "" {
+		return apis.NewBadRequestError("Purchase is missing the payment id or customer email", nil)
+	}
+
+	_, err := fulfillPurchase(app, processorName, p, payload)
+	if errors.Is(err, errTransactionReversed) {
+		// Refunded before it was fulfilled, acknowledge it so the processor stops retrying.
+		app.Logger().Warn("Purchase of a reversed payment not fulfilled", "processor", processorName, "processorId", p.ProcessorID)
+		return e.NoContent(http.StatusOK)
+	}
+	if err != nil {
+		// The failure is recorded on the transaction; a non-2xx status makes the processor retry.
+		return apis.NewApiError(http.StatusInternalServerError, "Failed to process purchase", err)
+	}
+
+	return e.NoContent(http.StatusOK)
+}
+
+// fulfillPurchase logs the transaction, makes sure the customer exists and issues them a license,
+// all in a single database transaction. It returns nil if the purchase was already fulfilled.
+//
+// If anything fails nothing is committed except a "failed" transaction record
+// with the error, which the next webhook retry or a manual reprocess picks up again.
+// Payments reversed before they were fulfilled return errTransactionReversed.
+func fulfillPurchase(app core.App, processorName string, p *purchase, payload any) (*issuedLicense, error) {
+	var issued *issuedLicense
+
+	err := app.RunInTransaction(func(txApp core.App) error {
+		// 1. Check if this transaction has already been processed.
+		// Re-read inside the db transaction so concurrent deliveries can't both pass.
+		transactionRecord, err := txApp.FindFirstRecordByFilter("transactions", "processor_id = {:id}", dbx.Params{"id": p.ProcessorID})
+		if err == nil && transactionRecord.GetString("status") == "reversed" {
+			return errTransactionReversed
+		}
+		if err == nil && transactionRecord.GetString("status") != "failed" {
+			return nil // Already fulfilled, do nothing.
+		}
+		if err != nil && err != sql.ErrNoRows {
+			return fmt.Errorf("checking transaction: %w", err)
+		}
+		if err == sql.ErrNoRows {
+			transactionCollection, err := txApp.FindCollectionByNameOrId("transactions")
+			if err != nil {
+				return err
+			}
+			transactionRecord = core.NewRecord(transactionCollection)
+		}
+
+		// 2. Log the transaction. It only commits together with the license below,
+		// so failed purchases never use up an invoice number.
+		invoiceNumber, err := nextInvoiceNumber(txApp)
+		if err != nil {
+			return fmt.Errorf("numbering invoice: %w", err)
+		}
+		transactionForm := forms.NewRecordUpsert(txApp, transactionRecord)
+		transactionForm.Load(map[string]any{
+			"processor":      processorName,
+			"processor_id":   p.ProcessorID,
+			"user_email":     p.CustomerEmail,
+			"user_name":      p.CustomerName,
+			"payload":        payload, // Store the raw payload for debugging
+			"status":         "completed",
+			"last_error":     "",
+			"attempts":       transactionRecord.GetInt("attempts") + 1,
+			"invoice_number": invoiceNumber,
+			"invoiced_at":    types.NowDateTime(),
+		})
+		transactionForm.Load(p.invoiceFields())
+		if err := transactionForm.Submit(); err != nil {
+			return fmt.Errorf("logging transaction: %w", err)
+		}
+
+		sanitizedEmail := strings.ToLower(strings.TrimSpace(p.CustomerEmail))
+		userRecord, err := txApp.FindFirstRecordByFilter("users", "email = {:email}", dbx.Params{"email": sanitizedEmail})
+		if err != nil {
+			// Customers never sign in with a password, a random one only satisfies the auth collection.
+			password := security.RandomString(30)
+			userCollection, err := txApp.FindCollectionByNameOrId("users")
+			if err != nil {
+				return err
+			}
+			userRecord = core.NewRecord(userCollection)
+			userForm := forms.NewRecordUpsert(txApp, userRecord)
+			userForm.Load(map[string]any{
+				"email":           sanitizedEmail,
+				"name":            p.CustomerName,
+				"password":        password,
+				"passwordConfirm": password,
+			})
+			if err := userForm.Submit(); err != nil {
+				return fmt.Errorf("creating user: %w", err)
+			}
+		}
+
+		newKey, err := GenerateUniqueKey(txApp)
+		if err != nil {
+			return fmt.Errorf("generating license key: %w", err)
+		}
+
+		licenseCollection, err := txApp.FindCollectionByNameOrId("licenses")
+		if err != nil {
+			return err
+		}
+		licenseRecord := core.NewRecord(licenseCollection)
+		licenseForm := forms.NewRecordUpsert(txApp, licenseRecord)
+		licenseForm.Load(map[string]any{
+			"user":             userRecord.Id,
+			"transaction":      transactionRecord.Id,
+			"purchase_id":      p.ProcessorID,
+			"status":           "active",
+			"tier":             "pro",
+			"activation_limit": defaultActivationLimit,
+		})
+		if period := licenseUpdatesPeriod(); period > 0 {
+			licenseRecord.Set("updates_expire_at", types.NowDateTime().Add(period))
+		}
+		if err := setLicenseKey(licenseRecord, newKey); err != nil {
+			return fmt.Errorf("hashing license key: %w", err)
+		}
+		if err := licenseForm.Submit(); err != nil {
+			return fmt.Errorf("creating license: %w", err)
+		}
+
+		// A broken invoice template must not keep the customer from their license,
+		// the invoice is issued on its first download instead.
+		invoice, err := issueInvoice(txApp, transactionRecord, licenseRecord, userLocale(txApp, sanitizedEmail))
+		if err != nil {
+			txApp.Logger().Error("Could not issue the invoice", "transaction", transactionRecord.Id, "error", err)
+			invoice = nil
+		}
+
+		// Queued in the same transaction, so the customer gets the key if and only if the license exists.
+		if err := QueueLicenseEmail(txApp, sanitizedEmail, p.CustomerName, newKey, invoice); err != nil {
+			return fmt.Errorf("queuing license email: %w", err)
+		}
+
+		issued = &issuedLicense{
+			License: licenseRecord,
+			Key:     newKey,
+			Email:   sanitizedEmail,
+			Name:    p.CustomerName,
+		}
+		return nil
+	})
+	if errors.Is(err, errTransactionReversed) {
+		return nil, err // not a failure, the transaction keeps its reversal
+	}
+	if err != nil {
+		recordFailedTransaction(app, processorName, p, payload, err)
+		return nil, err
+	}
+
+	return issued, nil
+}
+
+// recordFailedTransaction stores (or updates) the transaction in the "failed" state,
+// outside of the rolled back db transaction, so the failure stays visible and reprocessable.
+func recordFailedTransaction(app core.App, processorName string, p *purchase, payload any, cause error) {
+	transactionRecord, err := app.FindFirstRecordByFilter("transactions", "processor_id = {:id}", dbx.Params{"id": p.ProcessorID})
+	if err != nil {
+		transactionCollection, err := app.FindCollectionByNameOrId("transactions")
+		if err != nil {
+			app.Logger().Error("Failed to record failed transaction", "processorId", p.ProcessorID, "error", err)
+			return
+		}
+		transactionRecord = core.NewRecord(transactionCollection)
+	}
+
+	transactionForm := forms.NewRecordUpsert(app, transactionRecord)
+	transactionForm.Load(map[string]any{
+		"processor":    processorName,
+		"processor_id": p.ProcessorID,
+		"user_email":   p.CustomerEmail,
+		"user_name":    p.CustomerName,
+		"payload":      payload,
+		"status":       "failed",
+		"last_error":   cause.Error(),
+		"attempts":     transactionRecord.GetInt("attempts") + 1,
+	})
+	transactionForm.Load(p.invoiceFields())
+	if err := transactionForm.Submit(); err != nil {
+		app.Logger().Error("Failed to record failed transaction", "processorId", p.ProcessorID, "error", err, "cause", cause)
+		return
+	}
+
+	app.Logger().Error("Purchase processing failed", "transaction", transactionRecord.Id, "processorId", p.ProcessorID, "error", cause)
+}
+
+// handleReprocessTransaction lets a superuser retry a failed purchase from its stored transaction.
+func handleReprocessTransaction(app core.App) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		transactionRecord, err := app.FindRecordById("transactions", e.Request.PathValue("id"))
+		if err != nil {
+			return apis.NewNotFoundError("Transaction not found", nil)
+		}
+		if transactionRecord.GetString("status") == "reversed" {
+			return apis.NewBadRequestError("The payment was refunded or disputed, it can't be reprocessed", nil)
+		}
+		if transactionRecord.GetString("status") != "failed" {
+			return apis.NewBadRequestError("Only failed transactions can be reprocessed", nil)
+		}
+
+		p, err := purchaseFromTransaction(transactionRecord)
+		if err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Failed to process purchase", err)
+		}
+		issued, err := fulfillPurchase(app, transactionRecord.GetString("processor"), p, transactionRecord.Get("payload"))
+		if errors.Is(err, errTransactionReversed) {
+			return apis.NewBadRequestError("The payment was refunded or disputed, it can't be reprocessed", nil)
+		}
+		if err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Failed to process purchase", err)
+		}
+
+		result := map[string]any{"status": "completed", "license": nil}
+		if issued != nil {
+			result["license"] = issued.License.Id
+		}
+		return e.JSON(http.StatusOK, result)
+	}
+}
@@ -0,0 +1,124 @@
+package hooks
+
+import (
+	"net/http"
+	"sync"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestFulfillPurchaseRollsBackOnFailure(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	decodeTestResponse(t, sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_first", "first@example.com")), http.StatusOK, nil)
+	outboxBefore := countTestRecords(t, app, "email_outbox", nil)
+
+	// Fail the last step, after the license was created in the db transaction.
+	failing := true
+	app.OnRecordCreate("email_outbox").BindFunc(func(e *core.RecordEvent) error {
+		if failing {
+			return errTestFulfilment
+		}
+		return e.Next()
+	})
+	rec := sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_failing", "failing@example.com"))
+	decodeTestResponse(t, rec, http.StatusInternalServerError, nil)
+	failing = false
+
+	if n := countTestRecords(t, app, "licenses", dbx.HashExp{"purchase_id": "pi_failing"}); n != 0 {
+		t.Fatalf("expected the license to be rolled back, got %d", n)
+	}
+	if _, err := app.FindAuthRecordByEmail("users", "failing@example.com"); err == nil {
+		t.Fatal("expected the new customer to be rolled back")
+	}
+	if n := countTestRecords(t, app, "email_outbox", nil); n != outboxBefore {
+		t.Fatalf("expected no email to be queued, got %d new", n-outboxBefore)
+	}
+
+	failed := findTestTransaction(t, app, "pi_failing")
+	if status := failed.GetString("status"); status != "failed" {
+		t.Fatalf("expected the transaction to be failed, got %s", status)
+	}
+	if failed.GetString("last_error") == "" {
+		t.Fatal("expected the failure to be recorded")
+	}
+	if number := failed.GetInt("invoice_number"); number != 0 {
+		t.Fatalf("expected the failed purchase not to use an invoice number, got %d", number)
+	}
+
+	// The next purchase continues the numbering without a gap.
+	decodeTestResponse(t, sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_second", "second@example.com")), http.StatusOK, nil)
+	if number := findTestTransaction(t, app, "pi_second").GetInt("invoice_number"); number != 2 {
+		t.Fatalf("expected invoice number 2, got %d", number)
+	}
+}
+
+func TestReprocessTransactionIssuesOneLicense(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+	superuser := http.Header{"Authorization": {newTestSuperuserToken(t, app)}}
+
+	restore := failTestFulfilments(app)
+	sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_retry", "retry@example.com"))
+	restore()
+
+	transaction := findTestTransaction(t, app, "pi_retry")
+	reprocessURL := "/api/hooks/transactions/" + transaction.Id + "/reprocess"
+
+	// Reprocess by hand while the processor retries the webhook.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			sendTestRequest(router, http.MethodPost, reprocessURL, nil, superuser)
+		}()
+		go func() {
+			defer wg.Done()
+			sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_retry", "retry@example.com"))
+		}()
+	}
+	wg.Wait()
+
+	if n := countTestRecords(t, app, "licenses", dbx.HashExp{"purchase_id": "pi_retry"}); n != 1 {
+		t.Fatalf("expected exactly 1 license, got %d", n)
+	}
+	if n := countTestRecords(t, app, "email_outbox", dbx.HashExp{"to_email": "retry@example.com", "template": "license_key"}); n != 1 {
+		t.Fatalf("expected exactly 1 license email, got %d", n)
+	}
+
+	completed := findTestTransaction(t, app, "pi_retry")
+	if status := completed.GetString("status"); status != "completed" {
+		t.Fatalf("expected the transaction to be completed, got %s", status)
+	}
+	if number := completed.GetInt("invoice_number"); number != 1 {
+		t.Fatalf("expected invoice number 1, got %d", number)
+	}
+
+	// A completed transaction can't be reprocessed again.
+	decodeTestResponse(t, sendTestRequest(router, http.MethodPost, reprocessURL, nil, superuser), http.StatusBadRequest, nil)
+}
+
+func TestReprocessTransactionRequiresSuperuser(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	restore := failTestFulfilments(app)
+	sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_guarded", "guarded@example.com"))
+	restore()
+
+	transaction := findTestTransaction(t, app, "pi_guarded")
+	rec := sendTestRequest(router, http.MethodPost, "/api/hooks/transactions/"+transaction.Id+"/reprocess", nil, nil)
+	if rec.Code != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", rec.Code)
+	}
+	if n := countTestRecords(t, app, "licenses", dbx.HashExp{"purchase_id": "pi_guarded"}); n != 0 {
+		t.Fatalf("expected no license, got %d", n)
+	}
+}
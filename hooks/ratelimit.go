@@ -0,0 +1,199 @@
+package hooks
+
+import (
+	"errors"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// rateLimitSweepEvery is how many requests a limiter serves between sweeps of idle buckets.
+const rateLimitSweepEvery = 1000
+
+// rateQuota allows Burst requests per Period, refilled continuously.
+type rateQuota struct {
+	Burst  int
+	Period time.Duration
+}
+
+// Default quotas, each can be overridden with a RATE_LIMIT_<NAME> env var
+// formatted as "<burst>/<period>", e.g. RATE_LIMIT_ACTIVATE_IP=20/1h.
+var defaultRateQuotas = map[string]rateQuota{
+	"api_ip":               {Burst: 120, Period: time.Minute},
+	"activate_ip":          {Burst: 20, Period: time.Hour},
+	"activate_key":         {Burst: 10, Period: time.Hour},
+	"deactivate_ip":        {Burst: 10, Period: time.Hour},
+	"deactivate_key":       {Burst: 5, Period: time.Hour},
+	"app_check_key":        {Burst: 30, Period: time.Minute},
+	"request_license_ip":   {Burst: 10, Period: time.Hour},
+	"request_license_mail": {Burst: 3, Period: time.Hour},
+	"invoice_ip":           {Burst: 20, Period: time.Hour},
+	"invoice_key":          {Burst: 10, Period: time.Hour},
+	"portal_login_ip":      {Burst: 10, Period: time.Hour},
+	"portal_login_mail":    {Burst: 3, Period: time.Hour},
+	"portal_auth_ip":       {Burst: 20, Period: time.Hour},
+	"portal_resend_ip":     {Burst: 5, Period: time.Hour},
+}
+
+// rateQuotaFor returns the configured quota of a named limit.
+func rateQuotaFor(name string) rateQuota {
+	if raw := os.Getenv("RATE_LIMIT_" + strings.ToUpper(name)); raw != "" {
+		if quota, err := parseRateQuota(raw); err == nil {
+			return quota
+		}
+	}
+	return defaultRateQuotas[name]
+}
+
+// parseRateQuota parses a "<burst>/<period>" quota, e.g. "20/1h".
+func parseRateQuota(raw string) (rateQuota, error) {
+	burst, period, ok := strings.Cut(raw, "/")
+	if !ok {
+		return rateQuota{}, errors.New("must be formatted as <burst>/<period>, e.g. 20/1h")
+	}
+	b, err := strconv.Atoi(strings.TrimSpace(burst))
+	if err != nil || b <= 0 {
+		return rateQuota{}, errors.New("burst must be a positive integer")
+	}
+	p, err := time.ParseDuration(strings.TrimSpace(period))
+	if err != nil || p <= 0 {
+		return rateQuota{}, errors.New("period must be a positive duration")
+	}
+
+	return rateQuota{Burst: b, Period: p}, nil
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	limited bool // whether the last request was rejected, to log each lockout only once
+}
+
+// rateLimiter is an in-memory token bucket limiter keyed by an arbitrary subject (IP, key, email).
+type rateLimiter struct {
+	name  string
+	quota rateQuota
+
+	mu       sync.Mutex
+	buckets  map[string]*tokenBucket
+	requests int
+}
+
+func newRateLimiter(name string) *rateLimiter {
+	return &rateLimiter{
+		name:    name,
+		quota:   rateQuotaFor(name),
+		buckets: map[string]*tokenBucket{},
+	}
+}
+
+// allow takes a token from the subject's bucket. When the bucket is empty it returns
+// false with the time until the next token. firstDenial reports the start of a lockout.
+func (l *rateLimiter) allow(subject string, now time.Time) (ok bool, retryAfter time.Duration, firstDenial bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	refillRate := float64(l.quota.Burst) / l.quota.Period.Seconds() // tokens per second
+
+	l.requests++
+	if l.requests%rateLimitSweepEvery == 0 {
+		for s, b := range l.buckets {
+			if now.Sub(b.updated) >= l.quota.Period {
+				delete(l.buckets, s) // full again, same as a fresh bucket
+			}
+		}
+	}
+
+	b, exists := l.buckets[subject]
+	if !exists {
+		b = &tokenBucket{tokens: float64(l.quota.Burst), updated: now}
+		l.buckets[subject] = b
+	}
+
+	b.tokens = math.Min(float64(l.quota.Burst), b.tokens+now.Sub(b.updated).Seconds()*refillRate)
+	b.updated = now
+
+	if b.tokens >= 1 {
+		b.tokens--
+		b.limited = false
+		return true, 0, false
+	}
+
+	firstDenial = !b.limited
+	b.limited = true
+	retryAfter = time.Duration((1 - b.tokens) / refillRate * float64(time.Second))
+	return false, retryAfter, firstDenial
+}
+
+// rateLimit returns a middleware that limits requests per subject. The subject func
+// returns the bucket id and a label safe to log. Requests with an empty subject
+// (e.g. no key in the body) are not limited by it.
+func rateLimit(limiter *rateLimiter, subject func(e *core.RequestEvent) (id string, label string)) func(e *core.RequestEvent) error {
+	return func(e *core.RequestEvent) error {
+		id, label := subject(e)
+		if id == "" {
+			return e.Next()
+		}
+
+		ok, retryAfter, firstDenial := limiter.allow(id, time.Now())
+		if ok {
+			return e.Next()
+		}
+
+		if firstDenial {
+			// Record the start of each lockout so attacks are visible in the logs.
+			e.App.Logger().Warn(
+				"Rate limit exceeded",
+				"limit", limiter.name,
+				"subject", label,
+				"ip", e.RealIP(),
+				"path", e.Request.URL.Path,
+			)
+		}
+
+		e.Response.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		return apis.NewTooManyRequestsError("Too many requests, please try again later.", nil)
+	}
+}
+
+// rateLimitByIP uses the client IP as subject.
+func rateLimitByIP(name string) func(e *core.RequestEvent) error {
+	return rateLimit(newRateLimiter(name), func(e *core.RequestEvent) (string, string) {
+		return e.RealIP(), e.RealIP()
+	})
+}
+
+// rateLimitByKey uses the license key from the request body as subject.
+func rateLimitByKey(name string) func(e *core.RequestEvent) error {
+	return rateLimit(newRateLimiter(name), func(e *core.RequestEvent) (string, string) {
+		payload := struct {
+			Key string `json:"key"`
+		}{}
+		if err := e.BindBody(&payload); err != nil || payload.Key == "" {
+			return "", ""
+		}
+		// Never log full keys, only their masked form.
+		key := normalizeLicenseKey(payload.Key)
+		return key, maskLicenseKey(key)
+	})
+}
+
+// rateLimitByEmail uses the email from the request body as subject.
+func rateLimitByEmail(name string) func(e *core.RequestEvent) error {
+	return rateLimit(newRateLimiter(name), func(e *core.RequestEvent) (string, string) {
+		payload := struct {
+			Email string `json:"email"`
+		}{}
+		if err := e.BindBody(&payload); err != nil {
+			return "", ""
+		}
+		email := strings.ToLower(strings.TrimSpace(payload.Email))
+		return email, email
+	})
+}
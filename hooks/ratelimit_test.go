@@ -0,0 +1,126 @@
+package hooks
+
+import (
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestParseRateQuota(t *testing.T) {
+	scenarios := []struct {
+		raw      string
+		expected rateQuota
+		valid    bool
+	}{
+		{"20/1h", rateQuota{Burst: 20, Period: time.Hour}, true},
+		{" 3 / 90s ", rateQuota{Burst: 3, Period: 90 * time.Second}, true},
+		{"20", rateQuota{}, false},
+		{"0/1h", rateQuota{}, false},
+		{"-1/1h", rateQuota{}, false},
+		{"20/0s", rateQuota{}, false},
+		{"20/hour", rateQuota{}, false},
+	}
+
+	for _, s := range scenarios {
+		quota, err := parseRateQuota(s.raw)
+		if (err == nil) != s.valid {
+			t.Errorf("%q: expected valid %v, got error %v", s.raw, s.valid, err)
+			continue
+		}
+		if quota != s.expected {
+			t.Errorf("%q: expected %+v, got %+v", s.raw, s.expected, quota)
+		}
+	}
+}
+
+func TestRateLimiterRefill(t *testing.T) {
+	t.Setenv("RATE_LIMIT_TEST", "2/1m")
+	limiter := newRateLimiter("test")
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := limiter.allow("a", now); !ok {
+			t.Fatalf("request %d: expected the burst to be allowed", i+1)
+		}
+	}
+
+	ok, retryAfter, firstDenial := limiter.allow("a", now)
+	if ok || !firstDenial {
+		t.Fatalf("expected the first denial, got ok %v, firstDenial %v", ok, firstDenial)
+	}
+	if retryAfter != 30*time.Second {
+		t.Fatalf("expected to retry after 30s, got %s", retryAfter)
+	}
+	if ok, retryAfter, firstDenial = limiter.allow("a", now.Add(10*time.Second)); ok || firstDenial {
+		t.Fatalf("expected a repeated denial, got ok %v, firstDenial %v", ok, firstDenial)
+	}
+	if retryAfter != 20*time.Second {
+		t.Fatalf("expected to retry after 20s, got %s", retryAfter)
+	}
+
+	// Other subjects have their own bucket.
+	if ok, _, _ := limiter.allow("b", now); !ok {
+		t.Fatal("expected another subject to be allowed")
+	}
+
+	// One token is back after half the period, not two.
+	if ok, _, _ := limiter.allow("a", now.Add(30*time.Second)); !ok {
+		t.Fatal("expected a refilled token to be allowed")
+	}
+	if ok, _, firstDenial := limiter.allow("a", now.Add(30*time.Second)); ok || !firstDenial {
+		t.Fatalf("expected a new lockout, got ok %v, firstDenial %v", ok, firstDenial)
+	}
+
+	// An idle bucket refills up to the burst only.
+	later := now.Add(time.Hour)
+	for i := 0; i < 2; i++ {
+		if ok, _, _ := limiter.allow("a", later); !ok {
+			t.Fatalf("request %d: expected the refilled burst to be allowed", i+1)
+		}
+	}
+	if ok, _, _ := limiter.allow("a", later); ok {
+		t.Fatal("expected the bucket not to refill beyond the burst")
+	}
+}
+
+func TestActivateRateLimit(t *testing.T) {
+	t.Setenv("RATE_LIMIT_ACTIVATE_KEY", "2/1h")
+	t.Setenv("RATE_LIMIT_ACTIVATE_IP", "4/1h")
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	activate := func(key string) *http.Response {
+		return sendTestRequest(router, http.MethodPost, "/api/v1/activate", map[string]any{
+			"email":    "limited@example.com",
+			"key":      key,
+			"deviceId": "device-1",
+		}, nil).Result()
+	}
+
+	for i := 0; i < 2; i++ {
+		if res := activate("C1P-AAA-AAA"); res.StatusCode != http.StatusNotFound {
+			t.Fatalf("request %d: expected 404, got %d", i+1, res.StatusCode)
+		}
+	}
+
+	// The key is limited however it is written.
+	res := activate(" c1p-aaa-aaa ")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected 429, got %d", res.StatusCode)
+	}
+	if retryAfter := res.Header.Get("Retry-After"); retryAfter != "1800" {
+		t.Fatalf("expected Retry-After 1800, got %q", retryAfter)
+	}
+
+	// Another key still passes the key limit, until the IP limit kicks in.
+	if res := activate("C1P-BBB-BBB"); res.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for another key, got %d", res.StatusCode)
+	}
+	res = activate("C1P-CCC-CCC")
+	if res.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected the IP to be limited, got %d", res.StatusCode)
+	}
+	if retryAfter := res.Header.Get("Retry-After"); retryAfter != "900" {
+		t.Fatalf("expected Retry-After 900, got %q", retryAfter)
+	}
+}
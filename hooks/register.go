@@ -1,13 +1,32 @@
 package hooks
 
 import (
+	"fmt"
+
 	"github.com/pocketbase/pocketbase/core"
 )
 
 // Register attaches all application hooks and API routes to the Pocketbase instance.
+// It must be called before the app is started.
 func Register(app core.App) error {
+	// Refuse to serve with a broken configuration. This only runs for "serve",
+	// so the migrate and superuser commands keep working without the secrets.
+	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
+		if err := validateConfig(); err != nil {
+			return fmt.Errorf("invalid configuration:\n%w", err)
+		}
+		return e.Next()
+	})
+
+	registerLicenseKeyHooks(app)
+	registerVersionHooks(app)
+	registerArtifactHooks(app)
+	registerMailHooks(app)
+	registerOutbox(app)
+	registerPortalHooks(app)
+
 	// Register the API routes
 	registerAPIRoutes(app)
 
 	return nil
-}
\ No newline at end of file
+}
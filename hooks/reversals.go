@@ -0,0 +1,157 @@
+package hooks
+
+import (
+	"database/sql"
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/apis"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// reversalKind is the normalized type of a refund or dispute event.
+type reversalKind string
+
+const (
+	reversalRefund        reversalKind = "refund"
+	reversalDisputeOpened reversalKind = "dispute_opened"
+	reversalDisputeLost   reversalKind = "dispute_lost"
+	reversalDisputeWon    reversalKind = "dispute_won"
+)
+
+// reversal is a refund or dispute on a past purchase, normalized across processors.
+// Only full refunds are reversals: a partial refund (e.g. a goodwill discount) keeps
+// the license, so the processors don't report one.
+type reversal struct {
+	Kind        reversalKind
+	ProcessorID string // the payment id of the original purchase
+	Reason      string // free form explanation from the processor, if any
+}
+
+// reversalStatus returns the license status and reason a reversal moves a license to.
+// ok is false when the license should be left untouched.
+func reversalStatus(r *reversal, currentStatus string) (status string, reason string, ok bool) {
+	reason = string(r.Kind)
+	if r.Reason != "" {
+		reason += ": " + r.Reason
+	}
+
+	switch r.Kind {
+	case reversalRefund, reversalDisputeLost:
+		return "revoked", reason, currentStatus != "revoked"
+	case reversalDisputeOpened:
+		// Keep the license usable again if the dispute is won, but stop it for now.
+		return "on_hold", reason, currentStatus == "active"
+	case reversalDisputeWon:
+		return "active", reason, currentStatus == "on_hold"
+	}
+
+	return "", "", false
+}
+
+// errTransactionReversed is returned when a payment is fulfilled after it was refunded or disputed.
+var errTransactionReversed = errors.New("the payment was refunded or disputed")
+
+// processReversal finds the licenses issued for the reversed payment and updates their status.
+//
+// A payment no license was issued for yet (its fulfilment failed) is marked "reversed"
+// instead, so neither a late purchase webhook nor a reprocess issues one. A reversal
+// for a payment we haven't seen at all is refused, the processor retries it and it
+// applies once the purchase webhook was received.
+func processReversal(app core.App, e *core.RequestEvent, processorName string, r *reversal) error {
+	err := app.RunInTransaction(func(txApp core.App) error {
+		// Read in the db transaction, so a concurrent reprocess can't issue a license in between.
+		transaction, err := txApp.FindFirstRecordByFilter(
+			"transactions",
+			"processor = {:processor} && processor_id = {:id}",
+			dbx.Params{"processor": processorName, "id": r.ProcessorID},
+		)
+		if err != nil {
+			return err
+		}
+
+		if transaction.GetString("status") != "completed" {
+			return reverseUnfulfilledTransaction(txApp, transaction, r)
+		}
+		return reverseLicenses(txApp, transaction, processorName, r)
+	})
+	if errors.Is(err, sql.ErrNoRows) {
+		app.Logger().Warn("Reversal for an unknown transaction", "processor", processorName, "processorId", r.ProcessorID, "kind", string(r.Kind))
+		return apis.NewNotFoundError("Unknown payment, the purchase may not have been received yet.", nil)
+	}
+	if err != nil {
+		return apis.NewApiError(http.StatusInternalServerError, "Failed to process the reversal", err)
+	}
+
+	return e.NoContent(http.StatusOK)
+}
+
+// reverseUnfulfilledTransaction marks a payment without license as reversed. A won
+// dispute makes it reprocessable again, unless the payment was refunded meanwhile.
+func reverseUnfulfilledTransaction(app core.App, transaction *core.Record, r *reversal) error {
+	_, reason, _ := reversalStatus(r, "")
+	heldByDispute := transaction.GetString("status") == "reversed" &&
+		strings.HasPrefix(transaction.GetString("reversal"), string(reversalDisputeOpened))
+
+	switch r.Kind {
+	case reversalDisputeWon:
+		if !heldByDispute {
+			return nil
+		}
+		transaction.Set("status", "failed")
+	case reversalDisputeOpened:
+		if transaction.GetString("status") == "reversed" && !heldByDispute {
+			return nil // already refunded, a dispute doesn't change that
+		}
+		transaction.Set("status", "reversed")
+	default:
+		transaction.Set("status", "reversed")
+	}
+	transaction.Set("reversal", reason)
+	if err := app.Save(transaction); err != nil {
+		return err
+	}
+
+	app.Logger().Info(
+		"Unfulfilled transaction changed by payment reversal",
+		"transaction", transaction.Id,
+		"status", transaction.GetString("status"),
+		"reason", reason,
+	)
+	return nil
+}
+
+// reverseLicenses updates the status of the licenses issued for transaction.
+func reverseLicenses(app core.App, transaction *core.Record, processorName string, r *reversal) error {
+	licenses, err := app.FindRecordsByFilter("licenses", "transaction = {:id}", "", 0, 0, dbx.Params{"id": transaction.Id})
+	if err != nil {
+		return err
+	}
+
+	for _, license := range licenses {
+		status, reason, ok := reversalStatus(r, license.GetString("status"))
+		if !ok {
+			continue
+		}
+
+		license.Set("status", status)
+		license.Set("status_reason", reason)
+		license.Set("status_changed_at", time.Now().UTC())
+		if err := app.Save(license); err != nil {
+			return err
+		}
+
+		app.Logger().Info(
+			"License status changed by payment reversal",
+			"license", license.Id,
+			"status", status,
+			"reason", reason,
+			"processor", processorName,
+		)
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package hooks
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// findTestTransaction returns the transaction of the Stripe payment paymentIntent.
+func findTestTransaction(t *testing.T, app core.App, paymentIntent string) *core.Record {
+	t.Helper()
+
+	transaction, err := app.FindFirstRecordByFilter("transactions", "processor_id = {:id}", dbx.Params{"id": paymentIntent})
+	if err != nil {
+		t.Fatalf("transaction %s: %v", paymentIntent, err)
+	}
+	return transaction
+}
+
+var errTestFulfilment = errors.New("database is locked")
+
+// failTestFulfilments makes the fulfilment of every purchase fail until the returned func is called.
+func failTestFulfilments(app core.App) (restore func()) {
+	failing := true
+	app.OnRecordCreate("licenses").BindFunc(func(e *core.RecordEvent) error {
+		if failing {
+			return errTestFulfilment
+		}
+		return e.Next()
+	})
+	return func() { failing = false }
+}
+
+func TestReversalOfUnknownPayment(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	rec := sendStripeTestEvent(router, "charge.refunded", map[string]any{"payment_intent": "pi_unknown", "refunded": true})
+	if rec.Code < 300 {
+		t.Fatalf("expected the processor to retry a reversal of an unknown payment, got %d", rec.Code)
+	}
+	if n := countTestRecords(t, app, "transactions", nil); n != 0 {
+		t.Fatalf("expected no transaction, got %d", n)
+	}
+
+	// Delivered out of order: once the purchase is in, the retried refund revokes its license.
+	decodeTestResponse(t, sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_unknown", "late@example.com")), http.StatusOK, nil)
+	decodeTestResponse(t, sendStripeTestEvent(router, "charge.refunded", map[string]any{"payment_intent": "pi_unknown", "refunded": true}), http.StatusOK, nil)
+
+	license, err := app.FindFirstRecordByData("licenses", "purchase_id", "pi_unknown")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status := license.GetString("status"); status != "revoked" {
+		t.Fatalf("expected the license to be revoked, got %s", status)
+	}
+}
+
+func TestReversalOfFailedTransaction(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+	superuser := http.Header{"Authorization": {newTestSuperuserToken(t, app)}}
+
+	restore := failTestFulfilments(app)
+	rec := sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_refunded", "refunded@example.com"))
+	decodeTestResponse(t, rec, http.StatusInternalServerError, nil)
+	restore()
+
+	decodeTestResponse(t, sendStripeTestEvent(router, "charge.refunded", map[string]any{"payment_intent": "pi_refunded", "refunded": true}), http.StatusOK, nil)
+
+	transaction := findTestTransaction(t, app, "pi_refunded")
+	if status := transaction.GetString("status"); status != "reversed" {
+		t.Fatalf("expected the transaction to be reversed, got %s", status)
+	}
+	if reason := transaction.GetString("reversal"); reason != "refund" {
+		t.Fatalf("expected the refund to be recorded, got %q", reason)
+	}
+
+	// Neither a reprocess nor a late retry of the purchase webhook issues a license.
+	rec = sendTestRequest(router, http.MethodPost, "/api/hooks/transactions/"+transaction.Id+"/reprocess", nil, superuser)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+	rec = sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_refunded", "refunded@example.com"))
+	decodeTestResponse(t, rec, http.StatusOK, nil)
+
+	if n := countTestRecords(t, app, "licenses", dbx.HashExp{"purchase_id": "pi_refunded"}); n != 0 {
+		t.Fatalf("expected no license for a refunded payment, got %d", n)
+	}
+	if status := findTestTransaction(t, app, "pi_refunded").GetString("status"); status != "reversed" {
+		t.Fatalf("expected the transaction to stay reversed, got %s", status)
+	}
+}
+
+func TestReversalOfFailedTransactionByDispute(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+	superuser := http.Header{"Authorization": {newTestSuperuserToken(t, app)}}
+
+	restore := failTestFulfilments(app)
+	sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_disputed", "disputed@example.com"))
+	restore()
+
+	dispute := map[string]any{"payment_intent": "pi_disputed", "reason": "fraudulent", "status": "needs_response"}
+	decodeTestResponse(t, sendStripeTestEvent(router, "charge.dispute.created", dispute), http.StatusOK, nil)
+
+	transaction := findTestTransaction(t, app, "pi_disputed")
+	if status := transaction.GetString("status"); status != "reversed" {
+		t.Fatalf("expected the disputed transaction to be reversed, got %s", status)
+	}
+	rec := sendTestRequest(router, http.MethodPost, "/api/hooks/transactions/"+transaction.Id+"/reprocess", nil, superuser)
+	decodeTestResponse(t, rec, http.StatusBadRequest, nil)
+
+	// A won dispute makes the payment reprocessable again.
+	dispute["status"] = "won"
+	decodeTestResponse(t, sendStripeTestEvent(router, "charge.dispute.closed", dispute), http.StatusOK, nil)
+	if status := findTestTransaction(t, app, "pi_disputed").GetString("status"); status != "failed" {
+		t.Fatalf("expected the transaction to be failed again, got %s", status)
+	}
+
+	rec = sendTestRequest(router, http.MethodPost, "/api/hooks/transactions/"+transaction.Id+"/reprocess", nil, superuser)
+	decodeTestResponse(t, rec, http.StatusOK, nil)
+	if n := countTestRecords(t, app, "licenses", dbx.HashExp{"purchase_id": "pi_disputed"}); n != 1 {
+		t.Fatalf("expected 1 license after the reprocess, got %d", n)
+	}
+}
+
+func TestReversalOfRefundedTransactionKeepsRefund(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", stripeTestSecret)
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	restore := failTestFulfilments(app)
+	sendStripeTestEvent(router, "checkout.session.completed", stripeTestCheckout("pi_both", "both@example.com"))
+	restore()
+
+	dispute := map[string]any{"payment_intent": "pi_both", "reason": "fraudulent", "status": "won"}
+	decodeTestResponse(t, sendStripeTestEvent(router, "charge.refunded", map[string]any{"payment_intent": "pi_both", "refunded": true}), http.StatusOK, nil)
+	decodeTestResponse(t, sendStripeTestEvent(router, "charge.dispute.created", dispute), http.StatusOK, nil)
+	decodeTestResponse(t, sendStripeTestEvent(router, "charge.dispute.closed", dispute), http.StatusOK, nil)
+
+	transaction := findTestTransaction(t, app, "pi_both")
+	if status, reason := transaction.GetString("status"), transaction.GetString("reversal"); status != "reversed" || reason != "refund" {
+		t.Fatalf("expected the refund to stand, got %s (%s)", status, reason)
+	}
+}
@@ -0,0 +1,72 @@
+package hooks
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+)
+
+// Rollout states an admin can set on a version. An empty state means the
+// version is rolling out to its rollout_percentage of devices.
+const (
+	// rolloutPaused stops offering the version to devices that don't have it yet,
+	// the percentage is kept so the rollout resumes with the same devices.
+	// Forced updates (min_required_build) still go through.
+	rolloutPaused = "paused"
+
+	// rolloutHalted withdraws the version entirely, forced updates included,
+	// e.g. when a release turns out to be broken.
+	rolloutHalted = "halted"
+)
+
+// Yanking (is_yanked) goes further than halting: the version is withdrawn and
+// clients already running it are rolled back to the newest good build.
+
+// rolloutBucket deterministically places a device in one of 100 buckets for a version.
+// Hashing the version id too means each release samples a different set of devices.
+func rolloutBucket(deviceID, versionID string) int {
+	sum := sha256.Sum256([]byte(deviceID + ":" + versionID))
+	return int(binary.BigEndian.Uint64(sum[:8]) % 100)
+}
+
+// versionRolledOutTo reports whether version may be offered to a device on currentBuild.
+// Devices that must update because of min_required_build bypass the rollout gate.
+// Without a device id only fully rolled out versions are offered.
+func versionRolledOutTo(version *core.Record, deviceID string, currentBuild int) bool {
+	switch version.GetString("rollout_state") {
+	case rolloutHalted:
+		return false
+	case rolloutPaused:
+		return isForcedUpdate(version, currentBuild)
+	}
+
+	if isForcedUpdate(version, currentBuild) {
+		return true
+	}
+
+	percentage := version.GetInt("rollout_percentage")
+	if percentage >= 100 {
+		return true
+	}
+	if deviceID == "" {
+		return false
+	}
+	return rolloutBucket(deviceID, version.Id) < percentage
+}
+
+// isForcedUpdate reports whether a client on currentBuild is too old to keep running without version.
+func isForcedUpdate(version *core.Record, currentBuild int) bool {
+	minRequiredBuild := version.GetInt("min_required_build")
+	return minRequiredBuild > 0 && currentBuild < minRequiredBuild
+}
+
+// findYankedVersion returns the yanked version with build number build, or nil if build isn't yanked.
+func findYankedVersion(app core.App, build int) (*core.Record, error) {
+	versions, err := app.FindAllRecords("versions", dbx.HashExp{"build_number": build, "is_yanked": true})
+	if err != nil || len(versions) == 0 {
+		return nil, err
+	}
+	return versions[0], nil
+}
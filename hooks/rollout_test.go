@@ -0,0 +1,171 @@
+package hooks
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/pocketbase/pocketbase/core"
+)
+
+func TestRolloutBucket(t *testing.T) {
+	if rolloutBucket("device-1", "version-1") != rolloutBucket("device-1", "version-1") {
+		t.Fatal("expected the bucket to be deterministic")
+	}
+
+	// About a quarter of the devices land below 25, a different quarter for each version.
+	const devices = 10000
+	var inFirst, inBoth int
+	for i := 0; i < devices; i++ {
+		deviceID := fmt.Sprintf("device-%d", i)
+		first := rolloutBucket(deviceID, "version-1")
+		if first < 0 || first >= 100 {
+			t.Fatalf("bucket %d out of range", first)
+		}
+		if first < 25 {
+			inFirst++
+			if rolloutBucket(deviceID, "version-2") < 25 {
+				inBoth++
+			}
+		}
+	}
+	if inFirst < devices*22/100 || inFirst > devices*28/100 {
+		t.Fatalf("expected about 25%% of the devices, got %d of %d", inFirst, devices)
+	}
+	if inBoth < inFirst*20/100 || inBoth > inFirst*30/100 {
+		t.Fatalf("expected the versions to sample independent devices, %d of %d are in both", inBoth, inFirst)
+	}
+}
+
+// findTestDevices returns a device id inside and one outside the rollout of version.
+func findTestDevices(t *testing.T, version *core.Record) (inside, outside string) {
+	t.Helper()
+
+	percentage := version.GetInt("rollout_percentage")
+	for i := 0; inside == "" || outside == ""; i++ {
+		deviceID := fmt.Sprintf("mac-%d", i)
+		if rolloutBucket(deviceID, version.Id) < percentage {
+			inside = deviceID
+		} else {
+			outside = deviceID
+		}
+	}
+	return inside, outside
+}
+
+func TestAppCheckRollout(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	staged := newTestVersion(t, app, 2, map[string]any{"rollout_percentage": 30})
+	newTestArtifact(t, app, staged, platformMacOS, archUniversal, nil)
+
+	license, key := newTestLicense(t, app, "rollout@example.com", map[string]any{"activation_limit": 5})
+	inside, outside := findTestDevices(t, staged)
+	newTestDevice(t, app, license, inside)
+	newTestDevice(t, app, license, outside)
+
+	check := func(deviceID string, build int) testAppCheck {
+		t.Helper()
+		return sendTestAppCheck(t, router, map[string]any{"key": key, "deviceId": deviceID, "current_build_number": build})
+	}
+
+	if got := check(inside, 1).updateVersion(); got != "1.2" {
+		t.Fatalf("expected a device in the rollout to get 1.2, got %q", got)
+	}
+	if got := check(outside, 1).updateVersion(); got != "" {
+		t.Fatalf("expected a device outside the rollout to get nothing, got %q", got)
+	}
+
+	// Pausing keeps the percentage for when the rollout resumes.
+	staged.Set("rollout_state", rolloutPaused)
+	if err := app.Save(staged); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(inside, 1).updateVersion(); got != "" {
+		t.Fatalf("expected a paused rollout to offer nothing, got %q", got)
+	}
+
+	// Forced updates go through a paused rollout but not a halted one.
+	required := newTestVersion(t, app, 3, map[string]any{
+		"rollout_percentage": 1,
+		"rollout_state":      rolloutPaused,
+		"min_required_build": 3,
+	})
+	newTestArtifact(t, app, required, platformMacOS, archUniversal, nil)
+	if got := check(outside, 1); got.updateVersion() != "1.3" || !got.Update.ForceUpdate {
+		t.Fatalf("expected the forced update 1.3, got %+v", got.Update)
+	}
+	required.Set("rollout_state", rolloutHalted)
+	if err := app.Save(required); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(outside, 1).updateVersion(); got != "" {
+		t.Fatalf("expected a halted forced update to be withdrawn, got %q", got)
+	}
+
+	// Completing the rollout reaches every device.
+	staged.Set("rollout_state", "")
+	staged.Set("rollout_percentage", 100)
+	if err := app.Save(staged); err != nil {
+		t.Fatal(err)
+	}
+	for _, deviceID := range []string{inside, outside} {
+		if got := check(deviceID, 1).updateVersion(); got != "1.2" {
+			t.Fatalf("%s: expected 1.2, got %q", deviceID, got)
+		}
+	}
+}
+
+func TestAppCheckYankRollback(t *testing.T) {
+	app := newHubTestApp(t)
+	router := newTestRouter(t, app)
+
+	versions := map[int]*core.Record{}
+	for build := 2; build <= 4; build++ {
+		versions[build] = newTestVersion(t, app, build, nil)
+		newTestArtifact(t, app, versions[build], platformMacOS, archUniversal, nil)
+	}
+	versions[4].Set("is_yanked", true)
+	versions[4].Set("yank_reason", "Loses recordings")
+	if err := app.Save(versions[4]); err != nil {
+		t.Fatal(err)
+	}
+
+	license, key := newTestLicense(t, app, "yank@example.com", nil)
+	newTestDevice(t, app, license, "mac")
+	check := func(build int) testAppCheck {
+		t.Helper()
+		return sendTestAppCheck(t, router, map[string]any{"key": key, "deviceId": "mac", "current_build_number": build})
+	}
+
+	rollback := check(4)
+	if rollback.updateVersion() != "1.3" || !rollback.Update.ForceUpdate {
+		t.Fatalf("expected a forced rollback to 1.3, got %+v", rollback.Update)
+	}
+	if r := rollback.Update.Rollback; r == nil || r.YankedBuild != 4 || r.Reason != "Loses recordings" {
+		t.Fatalf("unexpected rollback %+v", r)
+	}
+
+	if got := check(3).updateVersion(); got != "" {
+		t.Fatalf("expected the yanked build not to be offered, got %q", got)
+	}
+	if got := check(2); got.updateVersion() != "1.3" || got.Update.Rollback != nil || got.Update.ForceUpdate {
+		t.Fatalf("expected a regular update to 1.3, got %+v", got.Update)
+	}
+
+	// Halted builds aren't a rollback target.
+	versions[3].Set("rollout_state", rolloutHalted)
+	if err := app.Save(versions[3]); err != nil {
+		t.Fatal(err)
+	}
+	if got := check(4).updateVersion(); got != "1.2" {
+		t.Fatalf("expected a rollback to 1.2, got %q", got)
+	}
+
+	// Once a fixed build is out, the yanked build updates to it instead.
+	newTestArtifact(t, app, newTestVersion(t, app, 5, nil), platformMacOS, archUniversal, nil)
+	rollback = check(4)
+	if rollback.updateVersion() != "1.5" || !rollback.Update.ForceUpdate || rollback.Update.Rollback == nil {
+		t.Fatalf("expected a forced update to 1.5, got %+v", rollback.Update)
+	}
+}
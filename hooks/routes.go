@@ -2,123 +2,138 @@ package hooks
 
 import (
 	"database/sql"
-	"fmt"
-	"log"
+	"errors"
 	"net/http"
-	"os"
 	"strings"
 	"time"
 
-	"github.com/pocketbase/dbx"
 	"github.com/pocketbase/pocketbase/apis"
 	"github.com/pocketbase/pocketbase/core"
-	"github.com/pocketbase/pocketbase/forms"
 )
 
 // registerAPIRoutes attaches all our custom API endpoints to the Pocketbase app.
 func registerAPIRoutes(app core.App) {
+	appcast := newAppcastCache(app)
+
 	// The OnServe hook is recommended for attaching routes.
 	app.OnServe().BindFunc(func(e *core.ServeEvent) error {
 		api := e.Router.Group("/api/v1")
-
-		api.POST("/app_check", handleAppCheck(app))
-		api.POST("/activate", handleActivate(app))
-		api.POST("/request_license", handleRequestLicense(app))
-
-		// Webhook can be registered separately or within the group.
-		e.Router.POST("/api/hooks/dodo_purchase", handleDodoPurchase(app))
+		api.BindFunc(rateLimitByIP("api_ip"))
+
+		api.POST("/app_check", handleAppCheck(app)).
+			BindFunc(rateLimitByKey("app_check_key"))
+		api.POST("/activate", handleActivate(app)).
+			BindFunc(rateLimitByIP("activate_ip"), rateLimitByKey("activate_key"))
+		api.POST("/deactivate", handleDeactivate(app)).
+			BindFunc(rateLimitByIP("deactivate_ip"), rateLimitByKey("deactivate_key"))
+		api.POST("/request_license", handleRequestLicense(app)).
+			BindFunc(rateLimitByIP("request_license_ip"), rateLimitByEmail("request_license_mail"))
+		api.POST("/invoice", handleInvoice(app)).
+			BindFunc(rateLimitByIP("invoice_ip"), rateLimitByKey("invoice_key"))
+		api.GET("/appcast.xml", handleAppcast(app, appcast))
+
+		// Customer portal, signed in with a magic link, see portal.go.
+		portal := api.Group("/portal")
+		portal.POST("/login", handlePortalLogin(app)).
+			BindFunc(rateLimitByIP("portal_login_ip"), rateLimitByEmail("portal_login_mail"))
+		portal.POST("/auth", handlePortalAuth(app)).
+			BindFunc(rateLimitByIP("portal_auth_ip"))
+		portal.GET("/licenses/{id}/invoice", handlePortalInvoice(app)).
+			Bind(apis.RequireAuth("users"))
+		portal.POST("/licenses/{id}/resend", handlePortalResendKey(app)).
+			Bind(apis.RequireAuth("users")).
+			BindFunc(rateLimitByIP("portal_resend_ip"))
+
+		// Payment processor webhooks, all feeding the same license pipeline.
+		e.Router.POST("/api/hooks/dodo_purchase", handlePaymentWebhook(app, dodoProcessor{}))
+		e.Router.POST("/api/hooks/stripe", handlePaymentWebhook(app, stripeProcessor{}))
+		e.Router.POST("/api/hooks/transactions/{id}/reprocess", handleReprocessTransaction(app)).
+			Bind(apis.RequireSuperuserAuth())
 
 		return e.Next()
 	})
 }
 
 
-func handleDodoPurchase(app core.App) func(e *core.RequestEvent) error {
+// handleActivate updated to the new handler signature.
+func handleActivate(app core.App) func(e *core.RequestEvent) error {
 	return func(e *core.RequestEvent) error {
-		// (Secret key verification would go here)
-		
 		payload := struct {
-			CustomerEmail string `json:"customer_email"`
-			CustomerName  string `json:"customer_name"`
-			TransactionID string `json:"transaction_id"`
+			Email      string `json:"email"`
+			Key        string `json:"key"`
+			DeviceID   string `json:"deviceId"`
+			DeviceName string `json:"deviceName"`
+			OSVersion  string `json:"osVersion"`
+			AppBuild   int    `json:"appBuild"`
 		}{}
-
 		if err := e.BindBody(&payload); err != nil {
-			return apis.NewBadRequestError("Invalid payload", err)
+			return apis.NewBadRequestError("Invalid request body", err)
+		}
+		if payload.DeviceID == "" {
+			return apis.NewBadRequestError("Device ID is required", nil)
 		}
 
-		// 1. Check if this transaction has already been processed.
-		_, err := app.FindFirstRecordByFilter("transactions", "processor_id = {:id}", dbx.Params{"id": payload.TransactionID})
-		if err == nil {
-			// A record was found, meaning we've already processed this.
-			// Return a success response to satisfy the webhook, but do nothing.
-			return e.NoContent(http.StatusOK)
+		// 1-2. Find the license by key and validate the user associated with it
+		license, err := findCustomerLicense(e.App, payload.Email, payload.Key)
+		if err != nil {
+			return apis.NewNotFoundError("License not found or invalid.", nil)
 		}
-		if err != nil && err != sql.ErrNoRows {
-			// A real database error occurred.
-			return apis.NewApiError(http.StatusInternalServerError, "Database error checking transaction", err)
+
+		// 3. Check license status
+		if license.GetString("status") != "active" {
+			return apis.NewForbiddenError("This license is not active.", nil)
 		}
-		
-		// 2. Log the transaction. This is our lock.
-		transactionCollection, _ := app.FindCollectionByNameOrId("transactions")
-		transactionRecord := core.NewRecord(transactionCollection)
-		transactionForm := forms.NewRecordUpsert(app, transactionRecord)
-		transactionForm.Load(map[string]any{
-			"processor":    "dodopayments",
-			"processor_id": payload.TransactionID,
-			"user_email":   payload.CustomerEmail,
-			"user_name":    payload.CustomerName,
-			"payload":      payload, // Store the raw payload for debugging
+
+		// 4. Activate the device
+		ok, err := activateDeviceIfNeeded(e.App, license, deviceInfo{
+			ID:        payload.DeviceID,
+			Name:      payload.DeviceName,
+			OSVersion: payload.OSVersion,
+			AppBuild:  payload.AppBuild,
 		})
-		if err := transactionForm.Submit(); err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Failed to log transaction", err)
-		}
-		
-		sanitizedEmail := strings.ToLower(strings.TrimSpace(payload.CustomerEmail))
-		userRecord, err := app.FindFirstRecordByFilter("users", "email = {:email}", dbx.Params{"email": sanitizedEmail})
 		if err != nil {
-			// (User creation logic remains the same)
-			userCollection, _ := app.FindCollectionByNameOrId("users")
-			userRecord = core.NewRecord(userCollection)
-			userForm := forms.NewRecordUpsert(app, userRecord)
-			userForm.Load(map[string]any{"email": sanitizedEmail, "name": payload.CustomerName})
-			if err := userForm.Submit(); err != nil {
-				return apis.NewApiError(http.StatusInternalServerError, "Failed to create user", err)
-			}
+			return apis.NewApiError(http.StatusInternalServerError, "Could not activate device.", err)
 		}
-
-		newKey, err := GenerateUniqueKey(app)
-		if err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Failed to generate license key", err)
+		if !ok {
+			return apis.NewForbiddenError("Activation limit reached.", nil)
 		}
-		
-		newSalt, err := GenerateSalt(32) // Assuming you create a simple salt generator
+
+		// 5. Sign a certificate so the app can verify the license offline
+		certificate, err := issueLicenseCertificate(license, payload.DeviceID, time.Now())
 		if err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Failed to generate key salt", err)
+			return apis.NewApiError(http.StatusInternalServerError, "Could not issue license certificate.", err)
 		}
 
-		licenseCollection, _ := app.FindCollectionByNameOrId("licenses")
-		licenseRecord := core.NewRecord(licenseCollection)
-		licenseForm := forms.NewRecordUpsert(app, licenseRecord)
-		licenseForm.Load(map[string]any{
-			"key":          newKey,
-			"key_salt":     newSalt,
-			"user":         userRecord.Id,
-			"transaction":  transactionRecord.Id, 
-			"status":       "active",
-			"tier":         "pro",
+		return e.JSON(http.StatusOK, map[string]string{
+			"status":      "success",
+			"tier":        license.GetString("tier"),
+			"certificate": certificate,
 		})
-		if err := licenseForm.Submit(); err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Failed to create license", err)
-		}
+	}
+}
+
+// findCustomerLicense looks up a license by key and checks that it belongs to email.
+func findCustomerLicense(app core.App, email, key string) (*core.Record, error) {
+	sanitizedEmail := strings.ToLower(strings.TrimSpace(email))
+
+	license, err := findLicenseByKey(app, key)
+	if err != nil {
+		return nil, err
+	}
 
-		go SendLicenseEmail(app, sanitizedEmail, payload.CustomerName, newKey)
-		return e.NoContent(http.StatusOK)
+	user, err := app.FindRecordById("users", license.GetString("user"))
+	if err != nil {
+		return nil, err
+	}
+	if user.GetString("email") != sanitizedEmail {
+		return nil, sql.ErrNoRows
 	}
+
+	return license, nil
 }
 
-// handleActivate updated to the new handler signature.
-func handleActivate(app core.App) func(e *core.RequestEvent) error {
+// handleDeactivate lets a customer free one of their license seats by themselves.
+func handleDeactivate(app core.App) func(e *core.RequestEvent) error {
 	return func(e *core.RequestEvent) error {
 		payload := struct {
 			Email    string `json:"email"`
@@ -132,38 +147,24 @@ func handleActivate(app core.App) func(e *core.RequestEvent) error {
 			return apis.NewBadRequestError("Device ID is required", nil)
 		}
 
-		sanitizedEmail := strings.ToLower(strings.TrimSpace(payload.Email))
-
-		// 1. Find the license by key
-		license, err := e.App.FindFirstRecordByFilter("licenses", "key = {:key}", map[string]any{"key": payload.Key})
+		license, err := findCustomerLicense(e.App, payload.Email, payload.Key)
 		if err != nil {
 			return apis.NewNotFoundError("License not found or invalid.", nil)
 		}
 
-		// 2. Validate the user associated with the license
-		user, err := e.App.FindRecordById("users", license.GetString("user"))
-		if err != nil || user.GetString("email") != sanitizedEmail {
-			return apis.NewNotFoundError("License not found or invalid.", nil)
-		}
-
-		// 3. Check license status
-		if license.GetString("status") != "active" {
-			return apis.NewForbiddenError("This license is not active.", nil)
+		ok, err := deactivateDevice(e.App, license, payload.DeviceID, e.RealIP(), time.Now())
+		if errors.Is(err, errDeactivationCooldown) || errors.Is(err, errDeactivationLimit) {
+			e.App.Logger().Warn("Deactivation refused", "license", license.Id, "deviceId", payload.DeviceID, "error", err.Error())
+			return apis.NewApiError(http.StatusTooManyRequests, "Too many deactivations for this license, please try again later.", nil)
 		}
-
-		// 4. Activate the device
-		ok, err := activateDeviceIfNeeded(e.App, license, payload.DeviceID) // Assuming this function exists
 		if err != nil {
-			return apis.NewApiError(http.StatusInternalServerError, "Could not activate device.", err)
+			return apis.NewApiError(http.StatusInternalServerError, "Could not deactivate device.", err)
 		}
 		if !ok {
-			return apis.NewForbiddenError("Activation limit reached.", nil)
+			return apis.NewNotFoundError("Device is not activated on this license.", nil)
 		}
 
-		return e.JSON(http.StatusOK, map[string]string{
-			"status": "success",
-			"tier":   license.GetString("tier"),
-		})
+		return e.JSON(http.StatusOK, map[string]string{"status": "success"})
 	}
 }
 
@@ -173,31 +174,62 @@ func handleAppCheck(app core.App) func(e *core.RequestEvent) error {
 		payload := struct {
 			Key                string `json:"key"`
 			DeviceID           string `json:"deviceId"`
+			OSVersion          string `json:"osVersion"`
 			CurrentBuildNumber int    `json:"current_build_number"`
+			Channel            string `json:"channel"`
+			Platform           string `json:"platform"`
+			Arch               string `json:"arch"`
 		}{}
 		if err := e.BindBody(&payload); err != nil {
 			return apis.NewBadRequestError("Invalid request body", err)
 		}
+		requestedChannel, ok := parseReleaseChannel(payload.Channel)
+		if !ok {
+			return apis.NewBadRequestError("Unknown release channel", nil)
+		}
+		arch, ok := normalizeArch(payload.Arch)
+		if !ok {
+			return apis.NewBadRequestError("Unknown architecture", nil)
+		}
 
 		// --- Activation Status Check ---
 		activationStatus := map[string]string{"status": "free", "tier": "free"}
+		var enrolledLicense, enrolledDevice *core.Record
 		if payload.Key != "" {
-			license, err := e.App.FindFirstRecordByFilter("licenses", "key = {:key}", map[string]any{"key": payload.Key})
+			license, err := findLicenseByKey(e.App, payload.Key)
 			if err == nil { // License exists
-				isValidOnDevice := false
-				for _, id := range license.GetStringSlice("activated_devices") {
-					if id == payload.DeviceID {
-						isValidOnDevice = true
-						break
-					}
-				}
+				device, err := findLicenseDevice(e.App, license, payload.DeviceID)
+				isValidOnDevice := err == nil && payload.DeviceID != ""
 
-				if license.GetString("status") == "active" && isValidOnDevice {
+				status := license.GetString("status")
+				switch {
+				case status == "active" && isValidOnDevice:
 					activationStatus["status"] = "active"
 					activationStatus["tier"] = license.GetString("tier")
+					enrolledLicense, enrolledDevice = license, device
+					if updatesExpireAt := license.GetDateTime("updates_expire_at"); !updatesExpireAt.IsZero() {
+						activationStatus["updates_expire_at"] = updatesExpireAt.String()
+					}
 					license.Set("last_checked_at", time.Now().UTC().Format(time.RFC3339))
 					_ = e.App.Save(license)
-				} else {
+
+					touchDevice(device, deviceInfo{
+						OSVersion: payload.OSVersion,
+						AppBuild:  payload.CurrentBuildNumber,
+					}, time.Now().UTC())
+					_ = e.App.Save(device)
+
+					// Renew the offline certificate so the grace period restarts from now.
+					if certificate, err := issueLicenseCertificate(license, payload.DeviceID, time.Now()); err == nil {
+						activationStatus["certificate"] = certificate
+					} else {
+						e.App.Logger().Error("Could not issue license certificate", "license", license.Id, "error", err)
+					}
+				case isValidOnDevice:
+					// Revoked or on hold (refund/chargeback), tell the device why it lost access.
+					activationStatus["status"] = status
+					activationStatus["reason"] = license.GetString("status_reason")
+				default:
 					activationStatus["status"] = "invalid"
 				}
 			} else {
@@ -205,38 +237,119 @@ func handleAppCheck(app core.App) func(e *core.RequestEvent) error {
 			}
 		}
 
-		baseURL := os.Getenv("PB_PUBLIC_URL") // e.g. https://api.example.com
-		if baseURL == "" {
-			log.Fatal("PB_PUBLIC_URL env var not set")
-		}
 		// --- Update Check ---
 		var updateInfo map[string]any = nil
 
-		latestVersions, err := app.FindRecordsByFilter(
+		channel := resolveReleaseChannel(requestedChannel, enrolledLicense, enrolledDevice)
+		channelExpr, params := channelFilter(channel)
+		params["build"] = payload.CurrentBuildNumber
+
+		// A client on a yanked build is rolled back to the newest good build, even an older one.
+		yankedVersion, err := findYankedVersion(e.App, payload.CurrentBuildNumber)
+		if err != nil {
+			return apis.NewApiError(http.StatusInternalServerError, "Could not look up the update.", err)
+		}
+		buildExpr := "build_number > {:build}"
+		if yankedVersion != nil {
+			buildExpr = "build_number != {:build}"
+		}
+
+		newerVersions, err := app.FindRecordsByFilter(
 			"versions",
-			"is_published = true AND build_number > {:build}",
+			"is_published = true && is_yanked = false && rollout_state != 'halted' && "+buildExpr+" && "+channelExpr,
 			"-build_number", // Sort by build_number descending
-			1, 0,
-			dbx.Params{"build": payload.CurrentBuildNumber},
+			0, 0,
+			params,
 		)
 
-		if err == nil && len(latestVersions) > 0 { // A newer version was found
-			latestVersion := latestVersions[0]
-			minRequiredBuild := latestVersion.GetInt("min_required_build")
-			isForceUpdate := false
-			if minRequiredBuild > 0 && payload.CurrentBuildNumber < minRequiredBuild {
-				isForceUpdate = true
+		// Offer the newest version this device is part of the rollout of, that has a build
+		// for its platform and architecture and that its license is entitled to. The newest
+		// build released after the entitlement expired is reported for an upgrade prompt.
+		// Forced updates and rollbacks from a yanked build fix broken clients, every client
+		// gets them whatever its license.
+		platform := normalizePlatform(payload.Platform)
+		var latestVersion, artifact, paidVersion *core.Record
+		if err == nil {
+			for _, version := range newerVersions {
+				if !versionRolledOutTo(version, payload.DeviceID, payload.CurrentBuildNumber) {
+					continue
+				}
+				versionArtifact, err := findVersionArtifact(e.App, version, platform, arch, parseOSVersion(payload.OSVersion))
+				if err != nil {
+					return apis.NewApiError(http.StatusInternalServerError, "Could not look up the update.", err)
+				}
+				if versionArtifact == nil {
+					continue
+				}
+				isRollback := yankedVersion != nil && version.GetInt("build_number") < payload.CurrentBuildNumber
+				mustUpdate := isRollback || isForcedUpdate(version, payload.CurrentBuildNumber)
+				if !mustUpdate && !licenseEntitledTo(enrolledLicense, version) {
+					if enrolledLicense != nil && paidVersion == nil {
+						paidVersion = version
+					}
+					continue
+				}
+				latestVersion, artifact = version, versionArtifact
+				break
 			}
+		}
 
-			// example.com/api/files/COLLECTION_ID_OR_NAME/RECORD_ID/FILENAME
-			fileUrl := fmt.Sprintf("%s/api/files/%s/%s/%s", baseURL, "versions", latestVersion.Id, latestVersion.GetString("download_url"))
+		if latestVersion != nil { // A newer version was found
+			isForceUpdate := yankedVersion != nil || isForcedUpdate(latestVersion, payload.CurrentBuildNumber)
+
+			// example.com/api/files/COLLECTION_ID/RECORD_ID/FILENAME
+			fileUrl, err := recordFileURL(e, artifact, artifact.GetString("file"))
+			if err != nil {
+				return apis.NewApiError(http.StatusInternalServerError, "Could not build the download URL.", err)
+			}
 
 			updateInfo = map[string]any{
 				"force_update":    isForceUpdate,
 				"version_string":  latestVersion.GetString("version_string"),
+				"channel":         latestVersion.GetString("channel"),
 				"release_notes":   latestVersion.GetString("release_notes"),
+				"os":              artifact.GetString("os"),
+				"arch":            artifact.GetString("arch"),
+				"min_os_version":  artifact.GetString("min_os_version"),
 				"download_url":    fileUrl,
-				"signature_eddsa": latestVersion.GetString("signature_eddsa"),
+				"signature_eddsa": artifact.GetString("signature_eddsa"),
+				"size":            artifact.GetInt("size"),
+				"sha256":          artifact.GetString("sha256"),
+			}
+
+			if yankedVersion != nil {
+				updateInfo["rollback"] = map[string]any{
+					"yanked_build": payload.CurrentBuildNumber,
+					"reason":       yankedVersion.GetString("yank_reason"),
+				}
+			}
+
+			// Smaller download for clients updating from a build a delta was generated from.
+			// The full artifact above stays the fallback if the delta fails to apply.
+			delta, err := findArtifactDelta(e.App, artifact, payload.CurrentBuildNumber)
+			if err != nil {
+				e.App.Logger().Error("Could not look up the update delta", "artifact", artifact.Id, "error", err)
+			}
+			if delta != nil {
+				deltaURL, err := recordFileURL(e, delta, delta.GetString("file"))
+				if err != nil {
+					return apis.NewApiError(http.StatusInternalServerError, "Could not build the download URL.", err)
+				}
+				updateInfo["delta"] = map[string]any{
+					"from_build":   delta.GetInt("from_build"),
+					"download_url": deltaURL,
+					"size":         delta.GetInt("size"),
+					"sha256":       delta.GetString("sha256"),
+				}
+			}
+		}
+
+		var upgradeInfo map[string]any = nil
+		if paidVersion != nil {
+			upgradeInfo = map[string]any{
+				"version_string": paidVersion.GetString("version_string"),
+				"channel":        paidVersion.GetString("channel"),
+				"released_at":    versionReleasedAt(paidVersion).String(),
 			}
 		}
 
@@ -244,6 +357,7 @@ func handleAppCheck(app core.App) func(e *core.RequestEvent) error {
 		return e.JSON(http.StatusOK, map[string]any{
 			"activation": activationStatus,
 			"update":     updateInfo,
+			"upgrade":    upgradeInfo, // newer builds the license isn't entitled to
 		})
 	}
 }
@@ -270,13 +384,10 @@ func handleRequestLicense(app core.App) func(e *core.RequestEvent) error {
 			return e.JSON(http.StatusOK, map[string]string{"status": "ok"})
 		}
 
-		var keys []string
-		for _, lic := range licenses {
-			keys = append(keys, lic.GetString("key"))
+		if err := queueLostLicenseEmail(e.App, user, licenses); err != nil {
+			e.App.Logger().Error("Could not queue the lost license email", "email", sanitizedEmail, "error", err)
 		}
 
-		go SendLicenseEmail(e.App, sanitizedEmail, user.GetString("name"), strings.Join(keys, "\n"))
-
 		return e.JSON(http.StatusOK, map[string]string{"status": "ok"})
 	}
 }
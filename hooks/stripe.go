@@ -0,0 +1,184 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const stripeSignatureHeader = "Stripe-Signature"
+
+// stripeProcessor handles Stripe webhooks, signed with the
+// endpoint secrets from STRIPE_WEBHOOK_SECRET.
+type stripeProcessor struct{}
+
+// stripeCheckoutSession is the subset of a Checkout Session object we rely on.
+type stripeCheckoutSession struct {
+	ID            string `json:"id"`
+	PaymentStatus string `json:"payment_status"`
+	PaymentIntent string `json:"payment_intent"`
+	AmountTotal   int64  `json:"amount_total"` // in the smallest currency unit, tax included
+	Currency      string `json:"currency"`     // lower case
+	TotalDetails  struct {
+		AmountTax int64 `json:"amount_tax"`
+	} `json:"total_details"`
+	CustomerDetails struct {
+		Email   string         `json:"email"`
+		Name    string         `json:"name"`
+		Address billingAddress `json:"address"` // same shape as a Stripe address
+	} `json:"customer_details"`
+}
+
+// stripeCharge is the subset of a Charge object we rely on for refunds.
+type stripeCharge struct {
+	PaymentIntent string `json:"payment_intent"`
+	Refunded      bool   `json:"refunded"` // only true once the charge is fully refunded
+}
+
+// stripeDispute is the subset of a Dispute object we rely on.
+type stripeDispute struct {
+	PaymentIntent string `json:"payment_intent"`
+	Reason        string `json:"reason"`
+	Status        string `json:"status"`
+}
+
+func (stripeProcessor) Name() string {
+	return "stripe"
+}
+
+// VerifyRequest checks the Stripe-Signature header ("t=<unix>,v1=<hex>,...")
+// against every configured endpoint secret.
+func (stripeProcessor) VerifyRequest(header http.Header, body []byte, now time.Time) error {
+	secrets := envSecrets("STRIPE_WEBHOOK_SECRET")
+	if len(secrets) == 0 {
+		return errWebhookNoSecret
+	}
+
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header.Get(stripeSignatureHeader), ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			if sig, err := hex.DecodeString(value); err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	if timestamp == "" || len(signatures) == 0 {
+		return errWebhookMissingHeaders
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errWebhookTimestamp
+	}
+	sentAt := time.Unix(unix, 0)
+	if sentAt.Before(now.Add(-webhookTolerance)) || sentAt.After(now.Add(webhookTolerance)) {
+		return errWebhookTimestamp
+	}
+
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, []byte(secret))
+		mac.Write([]byte(timestamp + "." + string(body)))
+		expected := mac.Sum(nil)
+
+		for _, sig := range signatures {
+			if hmac.Equal(sig, expected) {
+				return nil
+			}
+		}
+	}
+
+	return errWebhookSignature
+}
+
+func (stripeProcessor) ParseEvent(body []byte) (*processorEvent, error) {
+	envelope := struct {
+		Type string `json:"type"`
+		Data struct {
+			Object json.RawMessage `json:"object"`
+		} `json:"data"`
+	}{}
+	payload, err := decodeProcessorEvent(body, &envelope)
+	if err != nil {
+		return nil, err
+	}
+	return &processorEvent{Type: envelope.Type, Data: envelope.Data.Object, Payload: payload}, nil
+}
+
+func (stripeProcessor) Purchase(event *processorEvent) (*purchase, error) {
+	switch event.Type {
+	case "checkout.session.completed", "checkout.session.async_payment_succeeded":
+	default:
+		return nil, nil
+	}
+
+	session := stripeCheckoutSession{}
+	if err := json.Unmarshal(event.Data, &session); err != nil {
+		return nil, err
+	}
+
+	// Delayed payment methods complete the session before the money arrives,
+	// those are picked up by checkout.session.async_payment_succeeded instead.
+	if session.PaymentStatus == "unpaid" {
+		return nil, nil
+	}
+	if session.ID == "" {
+		return nil, errors.New("checkout session without an id")
+	}
+
+	// Refunds and disputes reference the payment intent, so prefer it as our id.
+	processorID := session.PaymentIntent
+	if processorID == "" {
+		processorID = session.ID
+	}
+
+	return &purchase{
+		ProcessorID:    processorID,
+		CustomerEmail:  session.CustomerDetails.Email,
+		CustomerName:   session.CustomerDetails.Name,
+		Amount:         session.AmountTotal,
+		Tax:            session.TotalDetails.AmountTax,
+		Currency:       strings.ToUpper(session.Currency),
+		BillingAddress: session.CustomerDetails.Address,
+	}, nil
+}
+
+func (stripeProcessor) Reversal(event *processorEvent) (*reversal, error) {
+	switch event.Type {
+	case "charge.refunded":
+		charge := stripeCharge{}
+		if err := json.Unmarshal(event.Data, &charge); err != nil {
+			return nil, err
+		}
+		if !charge.Refunded {
+			return nil, nil
+		}
+		return &reversal{Kind: reversalRefund, ProcessorID: charge.PaymentIntent}, nil
+	case "charge.dispute.created", "charge.dispute.closed":
+		dispute := stripeDispute{}
+		if err := json.Unmarshal(event.Data, &dispute); err != nil {
+			return nil, err
+		}
+		r := &reversal{Kind: reversalDisputeOpened, ProcessorID: dispute.PaymentIntent, Reason: dispute.Reason}
+		if event.Type == "charge.dispute.closed" {
+			// Anything but "lost" (e.g. "won" or "warning_closed") lifts the hold.
+			r.Kind = reversalDisputeWon
+			if dispute.Status == "lost" {
+				r.Kind = reversalDisputeLost
+			}
+		}
+		return r, nil
+	}
+
+	return nil, nil
+}
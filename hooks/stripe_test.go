@@ -0,0 +1,112 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const stripeTestBody = `{"type":"checkout.session.completed"}`
+
+// stripeTestSecret is the STRIPE_WEBHOOK_SECRET the webhook tests sign with.
+const stripeTestSecret = "whsec_test"
+
+// stripeTestSignature signs body the way Stripe does, returning the hex v1 signature.
+func stripeTestSignature(secret string, timestamp int64, body string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp, 10) + "." + body))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func stripeTestHeader(value string) http.Header {
+	header := http.Header{}
+	if value != "" {
+		header.Set(stripeSignatureHeader, value)
+	}
+	return header
+}
+
+// sendStripeTestEvent signs an event with stripeTestSecret and posts it to the Stripe webhook.
+func sendStripeTestEvent(handler http.Handler, eventType string, object map[string]any) *httptest.ResponseRecorder {
+	body, _ := json.Marshal(map[string]any{
+		"type": eventType,
+		"data": map[string]any{"object": object},
+	})
+	now := time.Now().Unix()
+	header := stripeTestHeader("t=" + strconv.FormatInt(now, 10) + ",v1=" + stripeTestSignature(stripeTestSecret, now, string(body)))
+	return sendTestRequest(handler, http.MethodPost, "/api/hooks/stripe", string(body), header)
+}
+
+// stripeTestCheckout is a paid Checkout Session of paymentIntent.
+func stripeTestCheckout(paymentIntent, email string) map[string]any {
+	return map[string]any{
+		"id":             "cs_" + paymentIntent,
+		"payment_status": "paid",
+		"payment_intent": paymentIntent,
+		"amount_total":   4900,
+		"currency":       "usd",
+		"customer_details": map[string]any{
+			"email": email,
+			"name":  "Test Customer",
+		},
+	}
+}
+
+func TestStripeVerifyRequest(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "whsec_old, whsec_new")
+
+	sentAt := time.Unix(1700000000, 0)
+	ts := strconv.FormatInt(sentAt.Unix(), 10)
+	signedOld := stripeTestSignature("whsec_old", sentAt.Unix(), stripeTestBody)
+	signedNew := stripeTestSignature("whsec_new", sentAt.Unix(), stripeTestBody)
+	signedOther := stripeTestSignature("whsec_other", sentAt.Unix(), stripeTestBody)
+
+	scenarios := []struct {
+		name     string
+		header   string
+		body     string
+		now      time.Time
+		expected error
+	}{
+		{"valid", "t=" + ts + ",v1=" + signedOld, stripeTestBody, sentAt, nil},
+		{"rotated secret", "t=" + ts + ",v1=" + signedNew, stripeTestBody, sentAt, nil},
+		{"several v1 entries", "t=" + ts + ",v1=" + signedOther + ",v1=" + signedNew + ",v0=" + signedOld, stripeTestBody, sentAt, nil},
+		{"only unknown secrets", "t=" + ts + ",v1=" + signedOther, stripeTestBody, sentAt, errWebhookSignature},
+		{"v0 only", "t=" + ts + ",v0=" + signedOld, stripeTestBody, sentAt, errWebhookMissingHeaders},
+		{"tampered body", "t=" + ts + ",v1=" + signedOld, `{"type":"charge.refunded"}`, sentAt, errWebhookSignature},
+		{"just inside the past tolerance", "t=" + ts + ",v1=" + signedOld, stripeTestBody, sentAt.Add(webhookTolerance), nil},
+		{"just inside the future tolerance", "t=" + ts + ",v1=" + signedOld, stripeTestBody, sentAt.Add(-webhookTolerance), nil},
+		{"just outside the past tolerance", "t=" + ts + ",v1=" + signedOld, stripeTestBody, sentAt.Add(webhookTolerance + time.Second), errWebhookTimestamp},
+		{"just outside the future tolerance", "t=" + ts + ",v1=" + signedOld, stripeTestBody, sentAt.Add(-webhookTolerance - time.Second), errWebhookTimestamp},
+		{"missing header", "", stripeTestBody, sentAt, errWebhookMissingHeaders},
+		{"missing timestamp", "v1=" + signedOld, stripeTestBody, sentAt, errWebhookMissingHeaders},
+		{"missing signature", "t=" + ts, stripeTestBody, sentAt, errWebhookMissingHeaders},
+		{"invalid timestamp", "t=soon,v1=" + signedOld, stripeTestBody, sentAt, errWebhookTimestamp},
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			err := stripeProcessor{}.VerifyRequest(stripeTestHeader(s.header), []byte(s.body), s.now)
+			if !errors.Is(err, s.expected) {
+				t.Fatalf("expected %v, got %v", s.expected, err)
+			}
+		})
+	}
+}
+
+func TestStripeVerifyRequestWithoutSecret(t *testing.T) {
+	t.Setenv("STRIPE_WEBHOOK_SECRET", "")
+
+	sentAt := time.Unix(1700000000, 0)
+	header := stripeTestHeader("t=1700000000,v1=" + stripeTestSignature("", sentAt.Unix(), stripeTestBody))
+	if err := (stripeProcessor{}).VerifyRequest(header, []byte(stripeTestBody), sentAt); !errors.Is(err, errWebhookNoSecret) {
+		t.Fatalf("expected %v, got %v", errWebhookNoSecret, err)
+	}
+}
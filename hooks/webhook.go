@@ -0,0 +1,107 @@
+package hooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Dodo Payments signs its deliveries following the Standard Webhooks spec
+// (https://www.standardwebhooks.com).
+const (
+	webhookIDHeader        = "webhook-id"
+	webhookTimestampHeader = "webhook-timestamp"
+	webhookSignatureHeader = "webhook-signature"
+	webhookSecretPrefix    = "whsec_"
+
+	// webhookTolerance is how far a delivery timestamp may drift from our clock
+	// before it is treated as a replay.
+	webhookTolerance = 5 * time.Minute
+)
+
+var (
+	errWebhookNoSecret       = errors.New("no webhook secret configured")
+	errWebhookMissingHeaders = errors.New("missing webhook signature headers")
+	errWebhookTimestamp      = errors.New("webhook timestamp outside the tolerance window")
+	errWebhookSignature      = errors.New("no matching webhook signature")
+)
+
+// envSecrets reads a comma separated list of secrets from an env var.
+// Several secrets can be active at once so they can be rotated without dropping deliveries.
+func envSecrets(envName string) []string {
+	var secrets []string
+	for _, raw := range strings.Split(os.Getenv(envName), ",") {
+		if raw = strings.TrimSpace(raw); raw != "" {
+			secrets = append(secrets, raw)
+		}
+	}
+	return secrets
+}
+
+// webhookSecrets decodes the "whsec_" prefixed Standard Webhooks secrets from an env var.
+func webhookSecrets(envName string) ([][]byte, error) {
+	var secrets [][]byte
+	for _, raw := range envSecrets(envName) {
+		secret, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(raw, webhookSecretPrefix))
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret in %s: %w", envName, err)
+		}
+		secrets = append(secrets, secret)
+	}
+	if len(secrets) == 0 {
+		return nil, errWebhookNoSecret
+	}
+	return secrets, nil
+}
+
+// verifyStandardWebhook checks the webhook-id, webhook-timestamp and webhook-signature
+// headers against the raw body. The delivery is accepted if any of the secrets
+// produced any of the (space separated) signatures.
+func verifyStandardWebhook(header http.Header, body []byte, secrets [][]byte, now time.Time) error {
+	id := header.Get(webhookIDHeader)
+	timestamp := header.Get(webhookTimestampHeader)
+	signatures := header.Get(webhookSignatureHeader)
+	if id == "" || timestamp == "" || signatures == "" {
+		return errWebhookMissingHeaders
+	}
+
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return errWebhookTimestamp
+	}
+	sentAt := time.Unix(unix, 0)
+	if sentAt.Before(now.Add(-webhookTolerance)) || sentAt.After(now.Add(webhookTolerance)) {
+		return errWebhookTimestamp
+	}
+
+	signedContent := []byte(id + "." + timestamp + "." + string(body))
+
+	for _, secret := range secrets {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signedContent)
+		expected := mac.Sum(nil)
+
+		for _, versioned := range strings.Fields(signatures) {
+			version, sig, ok := strings.Cut(versioned, ",")
+			if !ok || version != "v1" {
+				continue
+			}
+			decoded, err := base64.StdEncoding.DecodeString(sig)
+			if err != nil {
+				continue
+			}
+			if hmac.Equal(decoded, expected) {
+				return nil
+			}
+		}
+	}
+
+	return errWebhookSignature
+}
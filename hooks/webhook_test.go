@@ -0,0 +1,125 @@
+package hooks
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// Test vector of the Standard Webhooks spec.
+const (
+	specWebhookSecret    = "whsec_MfKQ9r8GKYqrTwjUPD8ILPZIo2LaLaSw"
+	specWebhookID        = "msg_p5jXN8AQM9LWM0D4loKWxJek"
+	specWebhookTimestamp = 1614265330
+	specWebhookBody      = `{"test": 2432232314}`
+	specWebhookSignature = "v1,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE="
+)
+
+func specWebhookHeader() http.Header {
+	header := http.Header{}
+	header.Set(webhookIDHeader, specWebhookID)
+	header.Set(webhookTimestampHeader, "1614265330")
+	header.Set(webhookSignatureHeader, specWebhookSignature)
+	return header
+}
+
+func specWebhookSecrets(t *testing.T, env string) [][]byte {
+	t.Helper()
+
+	t.Setenv("TEST_WEBHOOK_SECRET", env)
+	secrets, err := webhookSecrets("TEST_WEBHOOK_SECRET")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return secrets
+}
+
+func TestVerifyStandardWebhook(t *testing.T) {
+	sentAt := time.Unix(specWebhookTimestamp, 0)
+	secrets := specWebhookSecrets(t, specWebhookSecret)
+
+	type scenario struct {
+		name     string
+		header   func() http.Header
+		body     string
+		secrets  [][]byte
+		now      time.Time
+		expected error
+	}
+	scenarios := []scenario{
+		{"spec vector", specWebhookHeader, specWebhookBody, secrets, sentAt, nil},
+		{"just inside the past tolerance", specWebhookHeader, specWebhookBody, secrets, sentAt.Add(webhookTolerance), nil},
+		{"just inside the future tolerance", specWebhookHeader, specWebhookBody, secrets, sentAt.Add(-webhookTolerance), nil},
+		{"just outside the past tolerance", specWebhookHeader, specWebhookBody, secrets, sentAt.Add(webhookTolerance + time.Second), errWebhookTimestamp},
+		{"just outside the future tolerance", specWebhookHeader, specWebhookBody, secrets, sentAt.Add(-webhookTolerance - time.Second), errWebhookTimestamp},
+		{
+			"rotated secret",
+			specWebhookHeader, specWebhookBody,
+			specWebhookSecrets(t, "whsec_bmV3IHNlY3JldA==, "+specWebhookSecret),
+			sentAt, nil,
+		},
+		{"tampered body", specWebhookHeader, `{"test": 2432232315}`, secrets, sentAt, errWebhookSignature},
+		{"wrong secret", specWebhookHeader, specWebhookBody, specWebhookSecrets(t, "whsec_bmV3IHNlY3JldA=="), sentAt, errWebhookSignature},
+		{
+			"one of several signatures",
+			func() http.Header {
+				header := specWebhookHeader()
+				header.Set(webhookSignatureHeader, "v1,Zm9yZ2Vk v2,abc "+specWebhookSignature)
+				return header
+			},
+			specWebhookBody, secrets, sentAt, nil,
+		},
+		{
+			"unknown signature version",
+			func() http.Header {
+				header := specWebhookHeader()
+				header.Set(webhookSignatureHeader, "v2,g0hM9SsE+OTPJTGt/tmIKtSyZlE3uFJELVlNIOLJ1OE=")
+				return header
+			},
+			specWebhookBody, secrets, sentAt, errWebhookSignature,
+		},
+		{
+			"invalid timestamp",
+			func() http.Header {
+				header := specWebhookHeader()
+				header.Set(webhookTimestampHeader, "yesterday")
+				return header
+			},
+			specWebhookBody, secrets, sentAt, errWebhookTimestamp,
+		},
+	}
+
+	for _, name := range []string{webhookIDHeader, webhookTimestampHeader, webhookSignatureHeader} {
+		scenarios = append(scenarios, scenario{
+			"missing " + name,
+			func() http.Header {
+				header := specWebhookHeader()
+				header.Del(name)
+				return header
+			},
+			specWebhookBody, secrets, sentAt, errWebhookMissingHeaders,
+		})
+	}
+
+	for _, s := range scenarios {
+		t.Run(s.name, func(t *testing.T) {
+			err := verifyStandardWebhook(s.header(), []byte(s.body), s.secrets, s.now)
+			if !errors.Is(err, s.expected) {
+				t.Fatalf("expected %v, got %v", s.expected, err)
+			}
+		})
+	}
+}
+
+func TestWebhookSecrets(t *testing.T) {
+	t.Setenv("TEST_WEBHOOK_SECRET", "")
+	if _, err := webhookSecrets("TEST_WEBHOOK_SECRET"); !errors.Is(err, errWebhookNoSecret) {
+		t.Fatalf("expected %v, got %v", errWebhookNoSecret, err)
+	}
+
+	t.Setenv("TEST_WEBHOOK_SECRET", "whsec_not base64!")
+	if _, err := webhookSecrets("TEST_WEBHOOK_SECRET"); err == nil {
+		t.Fatal("expected an invalid secret to fail")
+	}
+}
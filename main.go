@@ -8,7 +8,6 @@ import (
 	"strings"
 
 	"github.com/pocketbase/pocketbase"
-	"github.com/pocketbase/pocketbase/core"
 	"github.com/pocketbase/pocketbase/plugins/migratecmd"
 
 	_ "cc-hub/migrations"
@@ -24,10 +23,11 @@ func main() {
 		Automigrate: isGoRun,
 	})
 
-	app.OnServe().BindFunc(func(se *core.ServeEvent) error {
-		hooks.Register(app)
-		return se.Next()
-	})
+	app.RootCmd.AddCommand(hooks.NewDeltaCommand(app))
+
+	if err := hooks.Register(app); err != nil {
+		log.Fatal(err)
+	}
 
 	if err := app.Start(); err != nil {
 		log.Fatal(err)
@@ -0,0 +1,153 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3174063690")
+		if err != nil {
+			return err
+		}
+
+		// update field (checkouts don't always collect a customer name)
+		if err := collection.Fields.AddMarshaledJSONAt(4, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text614609615",
+			"max": 0,
+			"min": 0,
+			"name": "user_name",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(6, []byte(`{
+			"hidden": false,
+			"id": "select2063623452",
+			"maxSelect": 1,
+			"name": "status",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "select",
+			"values": [
+				"completed",
+				"failed"
+			]
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text1066830442",
+			"max": 0,
+			"min": 0,
+			"name": "last_error",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"hidden": false,
+			"id": "number3217549156",
+			"max": null,
+			"min": 0,
+			"name": "attempts",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// the first webhook handler stored an invalid processor value
+		_, err = app.DB().NewQuery("UPDATE {{transactions}} SET [[processor]] = 'dodo' WHERE [[processor]] = 'dodopayments'").Execute()
+		if err != nil {
+			return err
+		}
+
+		// Transactions logged before the pipeline was transactional may have no license,
+		// mark those as failed so they can be reprocessed.
+		_, err = app.DB().NewQuery(`
+			UPDATE {{transactions}}
+			SET
+				[[status]] = CASE WHEN EXISTS (
+					SELECT 1 FROM {{licenses}}
+					WHERE {{licenses}}.[[transaction]] = {{transactions}}.[[id]]
+						OR {{licenses}}.[[purchase_id]] = {{transactions}}.[[processor_id]]
+				) THEN 'completed' ELSE 'failed' END,
+				[[attempts]] = 1
+			WHERE [[status]] = ''
+		`).Execute()
+		if err != nil {
+			return err
+		}
+
+		_, err = app.DB().NewQuery(`
+			UPDATE {{transactions}}
+			SET [[last_error]] = 'No license was issued for this transaction'
+			WHERE [[status]] = 'failed' AND [[last_error]] = ''
+		`).Execute()
+		return err
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3174063690")
+		if err != nil {
+			return err
+		}
+
+		// update field
+		if err := collection.Fields.AddMarshaledJSONAt(4, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text614609615",
+			"max": 0,
+			"min": 0,
+			"name": "user_name",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": true,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("select2063623452")
+
+		// remove field
+		collection.Fields.RemoveById("text1066830442")
+
+		// remove field
+		collection.Fields.RemoveById("number3217549156")
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,246 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "pbc_1065113382",
+					"hidden": false,
+					"id": "relation1466496025",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "license",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2493827028",
+					"max": 0,
+					"min": 0,
+					"name": "device_id",
+					"pattern": "",
+					"presentable": true,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1579384326",
+					"max": 100,
+					"min": 0,
+					"name": "name",
+					"pattern": "",
+					"presentable": true,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text2755536204",
+					"max": 0,
+					"min": 0,
+					"name": "os_version",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "number1802731286",
+					"max": null,
+					"min": 0,
+					"name": "app_build",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "date87546891",
+					"max": "",
+					"min": "",
+					"name": "first_activated_at",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "date"
+				},
+				{
+					"hidden": false,
+					"id": "date3088861482",
+					"max": "",
+					"min": "",
+					"name": "last_seen_at",
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "date"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_2153001328",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_kWhbWKjHrV` + "`" + ` ON ` + "`" + `devices` + "`" + ` (` + "`" + `license` + "`" + `, ` + "`" + `device_id` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "devices",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// move the activated_devices json arrays into device records
+		licenses, err := app.FindAllRecords("licenses")
+		if err != nil {
+			return err
+		}
+		for _, license := range licenses {
+			seen := map[string]bool{}
+			for _, deviceID := range license.GetStringSlice("activated_devices") {
+				if deviceID == "" || seen[deviceID] {
+					continue
+				}
+				seen[deviceID] = true
+
+				device := core.NewRecord(collection)
+				device.Set("license", license.Id)
+				device.Set("device_id", deviceID)
+				device.Set("first_activated_at", license.GetDateTime("created"))
+				device.Set("last_seen_at", license.GetDateTime("last_checked_at"))
+				if err := app.Save(device); err != nil {
+					return err
+				}
+			}
+		}
+
+		licensesCollection, err := app.FindCollectionByNameOrId("pbc_1065113382")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		licensesCollection.Fields.RemoveById("json1388271230")
+
+		return app.Save(licensesCollection)
+	}, func(app core.App) error {
+		licensesCollection, err := app.FindCollectionByNameOrId("pbc_1065113382")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := licensesCollection.Fields.AddMarshaledJSONAt(10, []byte(`{
+			"hidden": false,
+			"id": "json1388271230",
+			"maxSize": 0,
+			"name": "activated_devices",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "json"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(licensesCollection); err != nil {
+			return err
+		}
+
+		// fold the device records back into the json arrays
+		devices, err := app.FindAllRecords("devices")
+		if err != nil {
+			return err
+		}
+		activatedDevices := map[string][]string{}
+		for _, device := range devices {
+			licenseID := device.GetString("license")
+			activatedDevices[licenseID] = append(activatedDevices[licenseID], device.GetString("device_id"))
+		}
+		for licenseID, deviceIDs := range activatedDevices {
+			license, err := app.FindRecordById("licenses", licenseID)
+			if err != nil {
+				return err
+			}
+			license.Set("activated_devices", deviceIDs)
+			if err := app.SaveNoValidate(license); err != nil {
+				return err
+			}
+		}
+
+		collection, err := app.FindCollectionByNameOrId("pbc_2153001328")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,259 @@
+package migrations
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/security"
+)
+
+// The helpers below intentionally duplicate the hooks package key scheme
+// so this migration keeps working if the scheme changes later on.
+
+func licenseKeyDigest(secret, data string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(data))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func licenseKeySealKey(secret string) string {
+	sum := sha256.Sum256([]byte("seal:" + secret))
+	return hex.EncodeToString(sum[:])[:32]
+}
+
+func maskLicenseKey(key string) string {
+	masked := []byte(key)
+	for i := len("C1P"); i < len(masked)-2; i++ {
+		if masked[i] != '-' {
+			masked[i] = '*'
+		}
+	}
+	return string(masked)
+}
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1065113382")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(4, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text4213526617",
+			"max": 0,
+			"min": 0,
+			"name": "key_lookup",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": true,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(5, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": true,
+			"id": "text1472182641",
+			"max": 0,
+			"min": 0,
+			"name": "key_hash",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": true,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(6, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2999048346",
+			"max": 0,
+			"min": 0,
+			"name": "key_hint",
+			"pattern": "",
+			"presentable": true,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": true,
+			"id": "text4137508652",
+			"max": 0,
+			"min": 0,
+			"name": "key_sealed",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": true,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// hash and seal the existing plaintext keys
+		licenses, err := app.FindAllRecords("licenses")
+		if err != nil {
+			return err
+		}
+		if len(licenses) > 0 {
+			secret := os.Getenv("LICENSE_KEY_SECRET")
+			if len(secret) < 32 {
+				return errors.New("LICENSE_KEY_SECRET must be set to at least 32 characters to migrate the existing license keys")
+			}
+
+			for _, license := range licenses {
+				key := strings.ToUpper(strings.TrimSpace(license.GetString("key")))
+				sealed, err := security.Encrypt([]byte(key), licenseKeySealKey(secret))
+				if err != nil {
+					return err
+				}
+
+				license.Set("key_lookup", licenseKeyDigest(secret, "lookup:"+key)[:8])
+				license.Set("key_hash", licenseKeyDigest(secret, "hash:"+license.GetString("key_salt")+":"+key))
+				license.Set("key_hint", maskLicenseKey(key))
+				license.Set("key_sealed", sealed)
+				if err := app.SaveNoValidate(license); err != nil {
+					return err
+				}
+			}
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE UNIQUE INDEX `+"`"+`idx_4SU85C84FK`+"`"+` ON `+"`"+`licenses`+"`"+` (`+"`"+`key_salt`+"`"+`)",
+				"CREATE INDEX `+"`"+`idx_3Luxd6v6ep`+"`"+` ON `+"`"+`licenses`+"`"+` (`+"`"+`key_lookup`+"`"+`)"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("text2324736937")
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1065113382")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(3, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2324736937",
+			"max": 12,
+			"min": 9,
+			"name": "key",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// decrypt the keys back into plaintext
+		licenses, err := app.FindAllRecords("licenses")
+		if err != nil {
+			return err
+		}
+		if len(licenses) > 0 {
+			secret := os.Getenv("LICENSE_KEY_SECRET")
+			if len(secret) < 32 {
+				return errors.New("LICENSE_KEY_SECRET must be set to at least 32 characters to restore the license keys")
+			}
+
+			for _, license := range licenses {
+				key, err := security.Decrypt(license.GetString("key_sealed"), licenseKeySealKey(secret))
+				if err != nil {
+					return err
+				}
+				license.Set("key", string(key))
+				if err := app.SaveNoValidate(license); err != nil {
+					return err
+				}
+			}
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE UNIQUE INDEX `+"`"+`idx_4d33psKmkI`+"`"+` ON `+"`"+`licenses`+"`"+` (`+"`"+`key`+"`"+`)",
+				"CREATE UNIQUE INDEX `+"`"+`idx_4SU85C84FK`+"`"+` ON `+"`"+`licenses`+"`"+` (`+"`"+`key_salt`+"`"+`)"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// update field
+		if err := collection.Fields.AddMarshaledJSONAt(3, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text2324736937",
+			"max": 12,
+			"min": 9,
+			"name": "key",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": true,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("text4213526617")
+
+		// remove field
+		collection.Fields.RemoveById("text1472182641")
+
+		// remove field
+		collection.Fields.RemoveById("text2999048346")
+
+		// remove field
+		collection.Fields.RemoveById("text4137508652")
+
+		return app.Save(collection)
+	})
+}
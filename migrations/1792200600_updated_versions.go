@@ -0,0 +1,110 @@
+package migrations
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1502746827")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(5, []byte(`{
+			"hidden": false,
+			"id": "number4247811547",
+			"max": null,
+			"min": 0,
+			"name": "binary_size",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(6, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3178946716",
+			"max": 64,
+			"min": 0,
+			"name": "binary_sha256",
+			"pattern": "^[a-f0-9]*$",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// measure the already uploaded binaries
+		versions, err := app.FindAllRecords(collection)
+		if err != nil {
+			return err
+		}
+		if len(versions) == 0 {
+			return nil
+		}
+
+		fsys, err := app.NewFilesystem()
+		if err != nil {
+			return err
+		}
+		defer fsys.Close()
+
+		for _, version := range versions {
+			if version.GetString("binary") == "" {
+				continue
+			}
+
+			r, err := fsys.GetReader(version.BaseFilesPath() + "/" + version.GetString("binary"))
+			if err != nil {
+				return err
+			}
+			h := sha256.New()
+			size, err := io.Copy(h, r)
+			r.Close()
+			if err != nil {
+				return err
+			}
+
+			version.Set("binary_size", size)
+			version.Set("binary_sha256", hex.EncodeToString(h.Sum(nil)))
+			if err := app.SaveNoValidate(version); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1502746827")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("number4247811547")
+
+		// remove field
+		collection.Fields.RemoveById("text3178946716")
+
+		return app.Save(collection)
+	})
+}
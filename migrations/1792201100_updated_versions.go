@@ -0,0 +1,70 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1502746827")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(10, []byte(`{
+			"hidden": false,
+			"id": "number1181854076",
+			"max": 100,
+			"min": 1,
+			"name": "rollout_percentage",
+			"onlyInt": true,
+			"presentable": false,
+			"required": true,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(11, []byte(`{
+			"hidden": false,
+			"id": "select4180354632",
+			"maxSelect": 1,
+			"name": "rollout_state",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "select",
+			"values": [
+				"paused",
+				"halted"
+			]
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		// the versions published so far are already out to everyone
+		_, err = app.DB().NewQuery("UPDATE {{versions}} SET [[rollout_percentage]] = 100").Execute()
+		return err
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_1502746827")
+		if err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("number1181854076")
+
+		// remove field
+		collection.Fields.RemoveById("select4180354632")
+
+		return app.Save(collection)
+	})
+}
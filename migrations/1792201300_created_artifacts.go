@@ -0,0 +1,449 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/dbx"
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+	"github.com/pocketbase/pocketbase/tools/types"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "pbc_1502746827",
+					"hidden": false,
+					"id": "relation3206337475",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "version",
+					"presentable": true,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"hidden": false,
+					"id": "select1789936913",
+					"maxSelect": 1,
+					"name": "os",
+					"presentable": true,
+					"required": true,
+					"system": false,
+					"type": "select",
+					"values": [
+						"macos",
+						"windows",
+						"linux"
+					]
+				},
+				{
+					"hidden": false,
+					"id": "select4161937994",
+					"maxSelect": 1,
+					"name": "arch",
+					"presentable": true,
+					"required": true,
+					"system": false,
+					"type": "select",
+					"values": [
+						"universal",
+						"arm64",
+						"x86_64"
+					]
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text241929677",
+					"max": 20,
+					"min": 0,
+					"name": "min_os_version",
+					"pattern": "^[0-9.]*$",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "file2359244304",
+					"maxSelect": 1,
+					"maxSize": 1073741824,
+					"mimeTypes": [
+						"application/zip",
+						"application/x-apple-diskimage",
+						"application/vnd.microsoft.portable-executable",
+						"application/x-ms-installer"
+					],
+					"name": "file",
+					"presentable": false,
+					"protected": false,
+					"required": true,
+					"system": false,
+					"thumbs": [],
+					"type": "file"
+				},
+				{
+					"hidden": false,
+					"id": "number4156564586",
+					"max": null,
+					"min": 0,
+					"name": "size",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1556616439",
+					"max": 64,
+					"min": 0,
+					"name": "sha256",
+					"pattern": "^[a-f0-9]*$",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text3667559720",
+					"max": 0,
+					"min": 0,
+					"name": "signature_eddsa",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": true,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text4045769405",
+					"max": 0,
+					"min": 0,
+					"name": "signature_key_id",
+					"pattern": "",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_1303748624",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_tXz3FlgLLp` + "`" + ` ON ` + "`" + `artifacts` + "`" + ` (` + "`" + `version` + "`" + `, ` + "`" + `os` + "`" + `, ` + "`" + `arch` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "artifacts",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		if err := app.Save(collection); err != nil {
+			return err
+		}
+
+		versionsCollection, err := app.FindCollectionByNameOrId("pbc_1502746827")
+		if err != nil {
+			return err
+		}
+
+		// move the single binary of each version into a macOS universal artifact
+		versions, err := app.FindAllRecords(versionsCollection)
+		if err != nil {
+			return err
+		}
+		if len(versions) > 0 {
+			fsys, err := app.NewFilesystem()
+			if err != nil {
+				return err
+			}
+			defer fsys.Close()
+
+			for _, version := range versions {
+				filename := version.GetString("binary")
+				if filename == "" {
+					continue
+				}
+
+				// Inserted directly so the already published (and possibly never verified)
+				// signatures aren't checked again. The old file is left in place and goes
+				// away together with the version.
+				id := core.GenerateDefaultRandomId()
+				err := fsys.Copy(
+					version.BaseFilesPath()+"/"+filename,
+					collection.BaseFilesPath()+"/"+id+"/"+filename,
+				)
+				if err != nil {
+					return err
+				}
+
+				now := types.NowDateTime().String()
+				_, err = app.DB().Insert("artifacts", dbx.Params{
+					"id":               id,
+					"version":          version.Id,
+					"os":               "macos",
+					"arch":             "universal",
+					"min_os_version":   version.GetString("min_system_version"),
+					"file":             filename,
+					"size":             version.GetInt("binary_size"),
+					"sha256":           version.GetString("binary_sha256"),
+					"signature_eddsa":  version.GetString("signature_eddsa"),
+					"signature_key_id": version.GetString("signature_key_id"),
+					"created":          now,
+					"updated":          now,
+				}).Execute()
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		// remove field
+		versionsCollection.Fields.RemoveById("file3336628485")
+
+		// remove field
+		versionsCollection.Fields.RemoveById("number4247811547")
+
+		// remove field
+		versionsCollection.Fields.RemoveById("text3178946716")
+
+		// remove field
+		versionsCollection.Fields.RemoveById("text3667559720")
+
+		// remove field
+		versionsCollection.Fields.RemoveById("text4045769405")
+
+		// remove field
+		versionsCollection.Fields.RemoveById("text539922737")
+
+		return app.Save(versionsCollection)
+	}, func(app core.App) error {
+		versionsCollection, err := app.FindCollectionByNameOrId("pbc_1502746827")
+		if err != nil {
+			return err
+		}
+
+		// add field
+		if err := versionsCollection.Fields.AddMarshaledJSONAt(4, []byte(`{
+			"hidden": false,
+			"id": "file3336628485",
+			"maxSelect": 1,
+			"maxSize": 0,
+			"mimeTypes": [
+				"application/zip"
+			],
+			"name": "binary",
+			"presentable": false,
+			"protected": false,
+			"required": true,
+			"system": false,
+			"thumbs": [],
+			"type": "file"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := versionsCollection.Fields.AddMarshaledJSONAt(5, []byte(`{
+			"hidden": false,
+			"id": "number4247811547",
+			"max": null,
+			"min": 0,
+			"name": "binary_size",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := versionsCollection.Fields.AddMarshaledJSONAt(6, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3178946716",
+			"max": 64,
+			"min": 0,
+			"name": "binary_sha256",
+			"pattern": "^[a-f0-9]*$",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := versionsCollection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text3667559720",
+			"max": 0,
+			"min": 0,
+			"name": "signature_eddsa",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": true,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := versionsCollection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text4045769405",
+			"max": 0,
+			"min": 0,
+			"name": "signature_key_id",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := versionsCollection.Fields.AddMarshaledJSONAt(14, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text539922737",
+			"max": 20,
+			"min": 0,
+			"name": "min_system_version",
+			"pattern": "^[0-9.]*$",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		if err := app.Save(versionsCollection); err != nil {
+			return err
+		}
+
+		collection, err := app.FindCollectionByNameOrId("pbc_1303748624")
+		if err != nil {
+			return err
+		}
+
+		// move one macOS artifact per version back, preferring the universal build
+		artifacts, err := app.FindRecordsByFilter(collection, "os = 'macos'", "", 0, 0)
+		if err != nil {
+			return err
+		}
+		if len(artifacts) > 0 {
+			fsys, err := app.NewFilesystem()
+			if err != nil {
+				return err
+			}
+			defer fsys.Close()
+
+			restored := map[string]bool{}
+			for _, arch := range []string{"universal", "arm64", "x86_64"} {
+				for _, artifact := range artifacts {
+					versionID := artifact.GetString("version")
+					if artifact.GetString("arch") != arch || restored[versionID] {
+						continue
+					}
+					restored[versionID] = true
+
+					filename := artifact.GetString("file")
+					err := fsys.Copy(
+						artifact.BaseFilesPath()+"/"+filename,
+						versionsCollection.BaseFilesPath()+"/"+versionID+"/"+filename,
+					)
+					if err != nil {
+						return err
+					}
+
+					_, err = app.DB().Update("versions", dbx.Params{
+						"binary":             filename,
+						"binary_size":        artifact.GetInt("size"),
+						"binary_sha256":      artifact.GetString("sha256"),
+						"signature_eddsa":    artifact.GetString("signature_eddsa"),
+						"signature_key_id":   artifact.GetString("signature_key_id"),
+						"min_system_version": artifact.GetString("min_os_version"),
+					}, dbx.HashExp{"id": versionID}).Execute()
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+		return app.Delete(collection)
+	})
+}
@@ -0,0 +1,168 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		jsonData := `{
+			"createRule": null,
+			"deleteRule": null,
+			"fields": [
+				{
+					"autogeneratePattern": "[a-z0-9]{15}",
+					"hidden": false,
+					"id": "text3208210256",
+					"max": 15,
+					"min": 15,
+					"name": "id",
+					"pattern": "^[a-z0-9]+$",
+					"presentable": false,
+					"primaryKey": true,
+					"required": true,
+					"system": true,
+					"type": "text"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "pbc_1303748624",
+					"hidden": false,
+					"id": "relation1222991916",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "artifact",
+					"presentable": true,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"cascadeDelete": true,
+					"collectionId": "pbc_1303748624",
+					"hidden": false,
+					"id": "relation235569334",
+					"maxSelect": 1,
+					"minSelect": 0,
+					"name": "from_artifact",
+					"presentable": false,
+					"required": true,
+					"system": false,
+					"type": "relation"
+				},
+				{
+					"hidden": false,
+					"id": "number2228672452",
+					"max": null,
+					"min": 0,
+					"name": "from_build",
+					"onlyInt": true,
+					"presentable": true,
+					"required": true,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "number3063727083",
+					"max": null,
+					"min": 0,
+					"name": "to_build",
+					"onlyInt": true,
+					"presentable": true,
+					"required": true,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"hidden": false,
+					"id": "file2359244304",
+					"maxSelect": 1,
+					"maxSize": 1073741824,
+					"mimeTypes": [],
+					"name": "file",
+					"presentable": false,
+					"protected": false,
+					"required": true,
+					"system": false,
+					"thumbs": [],
+					"type": "file"
+				},
+				{
+					"hidden": false,
+					"id": "number4156564586",
+					"max": null,
+					"min": 0,
+					"name": "size",
+					"onlyInt": true,
+					"presentable": false,
+					"required": false,
+					"system": false,
+					"type": "number"
+				},
+				{
+					"autogeneratePattern": "",
+					"hidden": false,
+					"id": "text1556616439",
+					"max": 64,
+					"min": 0,
+					"name": "sha256",
+					"pattern": "^[a-f0-9]*$",
+					"presentable": false,
+					"primaryKey": false,
+					"required": false,
+					"system": false,
+					"type": "text"
+				},
+				{
+					"hidden": false,
+					"id": "autodate2990389176",
+					"name": "created",
+					"onCreate": true,
+					"onUpdate": false,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				},
+				{
+					"hidden": false,
+					"id": "autodate3332085495",
+					"name": "updated",
+					"onCreate": true,
+					"onUpdate": true,
+					"presentable": false,
+					"system": false,
+					"type": "autodate"
+				}
+			],
+			"id": "pbc_3548683211",
+			"indexes": [
+				"CREATE UNIQUE INDEX ` + "`" + `idx_4Zu3jdbqcl` + "`" + ` ON ` + "`" + `deltas` + "`" + ` (` + "`" + `artifact` + "`" + `, ` + "`" + `from_build` + "`" + `)",
+				"CREATE INDEX ` + "`" + `idx_G9gfNUXFB8` + "`" + ` ON ` + "`" + `deltas` + "`" + ` (` + "`" + `from_build` + "`" + `, ` + "`" + `to_build` + "`" + `)"
+			],
+			"listRule": null,
+			"name": "deltas",
+			"system": false,
+			"type": "base",
+			"updateRule": null,
+			"viewRule": null
+		}`
+
+		collection := &core.Collection{}
+		if err := json.Unmarshal([]byte(jsonData), &collection); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3548683211")
+		if err != nil {
+			return err
+		}
+
+		return app.Delete(collection)
+	})
+}
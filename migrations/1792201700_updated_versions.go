@@ -0,0 +1,20 @@
+package migrations
+
+import (
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		// published_at decides which builds a license is entitled to,
+		// date the versions published before it was set automatically
+		_, err := app.DB().NewQuery(
+			"UPDATE {{versions}} SET [[published_at]] = [[created]] WHERE [[is_published]] = TRUE AND [[published_at]] = ''",
+		).Execute()
+		return err
+	}, func(app core.App) error {
+		// the backfilled dates are kept, they are as good as any
+		return nil
+	})
+}
@@ -0,0 +1,158 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3174063690")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE UNIQUE INDEX `+"`"+`idx_01f0XDgvMZ`+"`"+` ON `+"`"+`transactions`+"`"+` (`+"`"+`processor_id`+"`"+`)",
+				"CREATE UNIQUE INDEX `+"`"+`idx_Vb2xHq9LwN`+"`"+` ON `+"`"+`transactions`+"`"+` (`+"`"+`invoice_number`+"`"+`) WHERE `+"`"+`invoice_number`+"`"+` > 0"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(5, []byte(`{
+			"hidden": false,
+			"id": "number2392944706",
+			"max": null,
+			"min": 0,
+			"name": "amount",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(6, []byte(`{
+			"hidden": false,
+			"id": "number3262847721",
+			"max": null,
+			"min": 0,
+			"name": "tax_amount",
+			"onlyInt": true,
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(7, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text1767278655",
+			"max": 3,
+			"min": 0,
+			"name": "currency",
+			"pattern": "^[A-Z]*$",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(8, []byte(`{
+			"hidden": false,
+			"id": "json1717625942",
+			"maxSize": 0,
+			"name": "billing_address",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "json"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(9, []byte(`{
+			"hidden": false,
+			"id": "number765886983",
+			"max": null,
+			"min": 0,
+			"name": "invoice_number",
+			"onlyInt": true,
+			"presentable": true,
+			"required": false,
+			"system": false,
+			"type": "number"
+		}`)); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(10, []byte(`{
+			"hidden": false,
+			"id": "date1022644031",
+			"max": "",
+			"min": "",
+			"name": "invoiced_at",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "date"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_3174063690")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"indexes": [
+				"CREATE UNIQUE INDEX `+"`"+`idx_01f0XDgvMZ`+"`"+` ON `+"`"+`transactions`+"`"+` (`+"`"+`processor_id`+"`"+`)"
+			]
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("number2392944706")
+
+		// remove field
+		collection.Fields.RemoveById("number3262847721")
+
+		// remove field
+		collection.Fields.RemoveById("text1767278655")
+
+		// remove field
+		collection.Fields.RemoveById("json1717625942")
+
+		// remove field
+		collection.Fields.RemoveById("number765886983")
+
+		// remove field
+		collection.Fields.RemoveById("date1022644031")
+
+		return app.Save(collection)
+	})
+}
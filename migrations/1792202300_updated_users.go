@@ -0,0 +1,80 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("_pb_users_auth_")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"listRule": "id = @request.auth.id",
+			"otp": {
+				"duration": 900
+			},
+			"updateRule": "id = @request.auth.id && @request.body.email:isset = false && @request.body.emailVisibility:isset = false && @request.body.verified:isset = false && @request.body.password:isset = false && @request.body.notes:isset = false",
+			"viewRule": "id = @request.auth.id"
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// update field
+		if err := collection.Fields.AddMarshaledJSONAt(9, []byte(`{
+			"convertURLs": false,
+			"hidden": true,
+			"id": "editor18589324",
+			"maxSize": 0,
+			"name": "notes",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "editor"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("_pb_users_auth_")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"listRule": null,
+			"otp": {
+				"duration": 180
+			},
+			"updateRule": null,
+			"viewRule": null
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// update field
+		if err := collection.Fields.AddMarshaledJSONAt(9, []byte(`{
+			"convertURLs": false,
+			"hidden": false,
+			"id": "editor18589324",
+			"maxSize": 0,
+			"name": "notes",
+			"presentable": false,
+			"required": false,
+			"system": false,
+			"type": "editor"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	})
+}
@@ -0,0 +1,67 @@
+package migrations
+
+import (
+	"encoding/json"
+
+	"github.com/pocketbase/pocketbase/core"
+	m "github.com/pocketbase/pocketbase/migrations"
+)
+
+func init() {
+	m.Register(func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_2153001328")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"deleteRule": "license.user = @request.auth.id",
+			"listRule": "license.user = @request.auth.id",
+			"updateRule": "license.user = @request.auth.id && @request.body.license:isset = false && @request.body.device_id:isset = false && @request.body.name:isset = false && @request.body.os_version:isset = false && @request.body.app_build:isset = false && @request.body.first_activated_at:isset = false && @request.body.update_channel:isset = false && @request.body.last_seen_at:isset = false",
+			"viewRule": "license.user = @request.auth.id"
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// add field
+		if err := collection.Fields.AddMarshaledJSONAt(4, []byte(`{
+			"autogeneratePattern": "",
+			"hidden": false,
+			"id": "text245846248",
+			"max": 100,
+			"min": 0,
+			"name": "label",
+			"pattern": "",
+			"presentable": false,
+			"primaryKey": false,
+			"required": false,
+			"system": false,
+			"type": "text"
+		}`)); err != nil {
+			return err
+		}
+
+		return app.Save(collection)
+	}, func(app core.App) error {
+		collection, err := app.FindCollectionByNameOrId("pbc_2153001328")
+		if err != nil {
+			return err
+		}
+
+		// update collection data
+		if err := json.Unmarshal([]byte(`{
+			"deleteRule": null,
+			"listRule": null,
+			"updateRule": null,
+			"viewRule": null
+		}`), &collection); err != nil {
+			return err
+		}
+
+		// remove field
+		collection.Fields.RemoveById("text245846248")
+
+		return app.Save(collection)
+	})
+}